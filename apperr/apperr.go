@@ -0,0 +1,145 @@
+// Package apperr defines the error type shared by the services, repositories
+// and transports, and the catalogue of error codes clients can rely on.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Code identifies a kind of error. Codes are part of the API and must never
+// change meaning once published.
+type Code string
+
+// The error code catalogue
+const (
+	// The request body or route could not be parsed
+	CodeMalformedRequest Code = "malformed_request"
+
+	// A parameter was missing or out of range
+	CodeInvalidArgument Code = "invalid_argument"
+
+	// One or more fields failed validation, see invalid-params
+	CodeValidationFailed Code = "validation_failed"
+
+	// The username or email address is already registered
+	CodeRegistrationExists Code = "registration_exists"
+
+	// No registration exists with the given id
+	CodeRegistrationNotFound Code = "registration_not_found"
+
+	// The request needs a valid session token
+	CodeUnauthenticated Code = "unauthenticated"
+
+	// The caller's role does not allow the request
+	CodeForbidden Code = "forbidden"
+
+	// The If-Match header names a version the registration no longer has
+	CodePreconditionFailed Code = "precondition_failed"
+
+	// The registration changed while the request was being handled
+	CodeVersionConflict Code = "version_conflict"
+
+	// The username or password is wrong
+	CodeInvalidCredentials Code = "invalid_credentials"
+
+	// An email verification or password reset code is unknown or expired
+	CodeInvalidToken Code = "invalid_token"
+
+	// The session token is unknown or was revoked
+	CodeInvalidSession Code = "invalid_session"
+
+	// The session token has expired
+	CodeSessionExpired Code = "session_expired"
+
+	// The client sent too many requests, see the Retry-After header
+	CodeRateLimited Code = "rate_limited"
+
+	// A backing service is temporarily unreachable, the request may be retried
+	CodeUnavailable Code = "unavailable"
+
+	// Anything else
+	CodeInternal Code = "internal"
+)
+
+// Error is a domain error. Errors match each other with errors.Is when their
+// codes are equal, so sentinels keep matching after Withf or Wrap.
+type Error struct {
+	Code      Code
+	Message   string
+	Status    int
+	Retryable bool
+	Cause     error
+
+	// How long to wait before retrying, if known
+	RetryAfter time.Duration
+}
+
+// New returns an error for a catalogue code, answered with the given status.
+func New(code Code, status int, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Status:  status,
+	}
+}
+
+var (
+	ErrUnavailable = &Error{
+		Code:      CodeUnavailable,
+		Message:   "Service Unavailable",
+		Status:    http.StatusServiceUnavailable,
+		Retryable: true,
+	}
+	ErrInternal = New(CodeInternal, http.StatusInternalServerError, "Internal Error")
+)
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Withf returns a copy of e whose message is followed by a formatted detail.
+func (e *Error) Withf(format string, args ...interface{}) *Error {
+	c := *e
+	c.Message = e.Message + " - " + fmt.Sprintf(format, args...)
+	return &c
+}
+
+// After returns a copy of e that may be retried once d has passed.
+func (e *Error) After(d time.Duration) *Error {
+	c := *e
+	c.Retryable = true
+	c.RetryAfter = d
+	return &c
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Cause = err
+	return &c
+}
+
+// From returns the domain error err is or wraps, treating any other error as
+// an internal one.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gofrs/uuid"
+)
+
+type ListEntriesRequest struct {
+	Query Query
+}
+
+type ListEntriesResponse struct {
+	Entries    []Entry `json:"entries"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Err        error   `json:"error,omitempty"`
+}
+
+// Entry is the representation of a model.AuditEntry.
+type Entry struct {
+	Id        uuid.UUID       `json:"id"`
+	ActorId   *uuid.UUID      `json:"actor_id"`
+	TargetId  uuid.UUID       `json:"target_id"`
+	Action    string          `json:"action"`
+	Changes   json.RawMessage `json:"changes"`
+	SourceIP  string          `json:"source_ip"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (r ListEntriesResponse) error() error {
+	return r.Err
+}
+
+func makeListEntriesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ListEntriesRequest)
+		page, err := s.List(ctx, req.Query)
+		if err != nil {
+			return ListEntriesResponse{Err: err}, nil
+		}
+
+		entries := make([]Entry, len(page.Entries))
+		for i, e := range page.Entries {
+			entries[i] = Entry{
+				Id:        e.Id,
+				TargetId:  e.TargetId,
+				Action:    e.Action,
+				Changes:   json.RawMessage(e.Changes),
+				SourceIP:  e.SourceIP,
+				CreatedAt: e.CreatedAt,
+			}
+			if e.ActorId.Valid {
+				actorId := e.ActorId.UUID
+				entries[i].ActorId = &actorId
+			}
+		}
+		return ListEntriesResponse{Entries: entries, NextCursor: page.NextCursor}, nil
+	}
+}
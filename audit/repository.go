@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	st "github.com/Masterminds/structable"
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/storage"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	AUDIT_TABLE = "audit_log"
+)
+
+var ErrInvalidArgument = apperr.New(apperr.CodeInvalidArgument,
+	http.StatusBadRequest, "Invalid Argument")
+
+type Repository interface {
+	// Save a new audit entry into the database
+	Store(ctx context.Context, entry *model.AuditEntry) error
+
+	// Get the entries matching a query, newest first
+	List(ctx context.Context, query Query) ([]*model.AuditEntry, error)
+}
+
+type repository struct {
+	Db         sq.DBProxyBeginner
+	DriverName string
+	Timeout    time.Duration
+}
+
+func NewRepository(db *sql.DB, driverName string, queryTimeout time.Duration) (Repository, error) {
+	return &repository{
+		Db:         storage.NewStmtCacheProxy(db),
+		DriverName: driverName,
+		Timeout:    queryTimeout,
+	}, nil
+}
+
+func (repo *repository) Store(ctx context.Context, entry *model.AuditEntry) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(AUDIT_TABLE, entry)
+	return storage.Insert(ctx, rec)
+}
+
+func (repo *repository) List(ctx context.Context, query Query) ([]*model.AuditEntry, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(AUDIT_TABLE, &model.AuditEntry{})
+	sel := rec.Builder().
+		Select(rec.Columns(true)...).
+		From(AUDIT_TABLE).
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(query.Limit))
+
+	if query.TargetId != uuid.Nil {
+		sel = sel.Where(sq.Eq{"target_id": query.TargetId})
+	}
+	if query.ActorId != uuid.Nil {
+		sel = sel.Where(sq.Eq{"actor_id": query.ActorId})
+	}
+	if query.Action != "" {
+		sel = sel.Where(sq.Eq{"action": query.Action})
+	}
+	if query.Before != nil {
+		sel = sel.Where(sq.Or{
+			sq.Lt{"created_at": query.Before.CreatedAt},
+			sq.And{
+				sq.Eq{"created_at": query.Before.CreatedAt},
+				sq.Lt{"id": query.Before.Id},
+			},
+		})
+	}
+
+	rows, err := sel.QueryContext(ctx)
+	if err != nil {
+		return nil, storage.Error(err)
+	}
+	defer rows.Close()
+
+	entries := []*model.AuditEntry{}
+	for rows.Next() {
+		entry := &model.AuditEntry{}
+		item := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(AUDIT_TABLE, entry)
+		if err := rows.Scan(item.FieldReferences(true)...); err != nil {
+			return nil, storage.Error(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, storage.Error(rows.Err())
+}
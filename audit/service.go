@@ -0,0 +1,157 @@
+// Package audit keeps a persistent record of changes made to accounts.
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/model"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gofrs/uuid"
+)
+
+// Redacted replaces the value of secret fields in recorded changes.
+const Redacted = "[REDACTED]"
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// Changes maps the name of each changed field to its new value.
+type Changes map[string]interface{}
+
+// Query selects audit entries, newest first.
+type Query struct {
+	// Only entries about this account, if set
+	TargetId uuid.UUID
+
+	// Only entries made by this account, if set
+	ActorId uuid.UUID
+
+	// Only entries for this action, if set
+	Action string
+
+	// Maximum number of entries per page, DefaultPageSize if zero
+	Limit int
+
+	// Opaque position returned as NextCursor by the previous page
+	Cursor string
+
+	// Only entries older than this position, set from Cursor
+	Before *Cursor
+}
+
+// Cursor is the position of an entry in the log.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	Id        uuid.UUID `json:"i"`
+}
+
+// Page is one page of audit entries.
+type Page struct {
+	Entries []*model.AuditEntry
+
+	// Cursor for the following page, empty on the last page
+	NextCursor string
+}
+
+type Service interface {
+	// Record an action on an account, attributed to the principal and source
+	// address found in ctx
+	Record(ctx context.Context, action string, targetId uuid.UUID, changes Changes) error
+
+	// List one page of entries matching a query
+	List(ctx context.Context, query Query) (*Page, error)
+}
+
+type service struct {
+	repository Repository
+}
+
+func NewService(repo Repository) Service {
+	return &service{repository: repo}
+}
+
+func (s *service) Record(ctx context.Context, action string, targetId uuid.UUID, changes Changes) error {
+	newId, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	if changes == nil {
+		changes = Changes{}
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	entry := &model.AuditEntry{
+		Id:        newId,
+		TargetId:  targetId,
+		Action:    action,
+		Changes:   string(encoded),
+		SourceIP:  sourceIP(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	if p := auth.FromContext(ctx); p != nil {
+		entry.ActorId = uuid.NullUUID{UUID: p.AccountId, Valid: true}
+	}
+
+	return s.repository.Store(ctx, entry)
+}
+
+func (s *service) List(ctx context.Context, query Query) (*Page, error) {
+	switch {
+	case query.Limit < 0:
+		return nil, ErrInvalidArgument.Withf("limit must not be negative")
+	case query.Limit == 0:
+		query.Limit = DefaultPageSize
+	case query.Limit > MaxPageSize:
+		query.Limit = MaxPageSize
+	}
+
+	if query.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidArgument.Withf("malformed cursor")
+		}
+		query.Before = &Cursor{}
+		if err := json.Unmarshal(raw, query.Before); err != nil {
+			return nil, ErrInvalidArgument.Withf("malformed cursor")
+		}
+	}
+
+	// Ask for one more than a page to learn whether another page follows
+	limit := query.Limit
+	query.Limit++
+	entries, err := s.repository.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		last := page.Entries[limit-1]
+		raw, _ := json.Marshal(Cursor{CreatedAt: last.CreatedAt, Id: last.Id})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	return page, nil
+}
+
+// sourceIP returns the address of the client that made the request, as put in
+// ctx by kithttp.PopulateRequestContext.
+func sourceIP(ctx context.Context) string {
+	addr, _ := ctx.Value(kithttp.ContextKeyRequestRemoteAddr).(string)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/problem"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+// MakeHandler serves the audit log to administrators, identified by the
+// bearer tokens resolve accepts.
+func MakeHandler(s Service, logger kitlog.Logger, resolve auth.Resolver) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(auth.HTTPToContext(resolve)),
+	}
+
+	listEntriesHandler := kithttp.NewServer(
+		auth.Authorize(auth.Admin)(makeListEntriesEndpoint(s)),
+		decodeListEntriesRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	r.Handle("/v1/audit", listEntriesHandler).Methods("GET")
+
+	return r
+}
+
+func decodeListEntriesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	query := Query{
+		Action: q.Get("action"),
+		Cursor: q.Get("cursor"),
+	}
+
+	var err error
+	if target := q.Get("target"); target != "" {
+		if query.TargetId, err = uuid.FromString(target); err != nil {
+			return nil, ErrInvalidArgument.Withf("target must be an account id")
+		}
+	}
+	if actor := q.Get("actor"); actor != "" {
+		if query.ActorId, err = uuid.FromString(actor); err != nil {
+			return nil, ErrInvalidArgument.Withf("actor must be an account id")
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, ErrInvalidArgument.Withf("limit must be an integer")
+		}
+	}
+
+	return ListEntriesRequest{Query: query}, nil
+}
+
+type errorer interface {
+	error() error
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	details := problem.FromError(err)
+	if details.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	problem.Write(w, details)
+}
+
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+
+	return json.NewEncoder(w).Encode(response)
+}
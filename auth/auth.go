@@ -0,0 +1,135 @@
+// Package auth carries the identity of the caller through a request.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/model"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gofrs/uuid"
+)
+
+var ErrUnauthenticated = apperr.New(apperr.CodeUnauthenticated,
+	http.StatusUnauthorized, "Authentication Required")
+var ErrForbidden = apperr.New(apperr.CodeForbidden,
+	http.StatusForbidden, "Forbidden")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	AccountId uuid.UUID
+	Role      string
+}
+
+// IsAdmin reports whether the principal holds the admin role.
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.Role == model.RoleAdmin
+}
+
+// Policy decides whether a principal, nil for anonymous callers, may make a
+// request. It returns ErrUnauthenticated or ErrForbidden if not.
+type Policy func(p *Principal, request interface{}) error
+
+// Authorize only lets requests through to next that policy allows. Requests
+// whose bearer token HTTPToContext rejected fail with the reason it was
+// rejected, whatever the policy.
+func Authorize(policy Policy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err, _ := ctx.Value(errKey).(error); err != nil {
+				return nil, err
+			}
+			if err := policy(FromContext(ctx), request); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Anyone allows every caller, anonymous or not.
+func Anyone(_ *Principal, _ interface{}) error {
+	return nil
+}
+
+// Authenticated allows any principal.
+func Authenticated(p *Principal, _ interface{}) error {
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Admin allows administrators only.
+func Admin(p *Principal, _ interface{}) error {
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if !p.IsAdmin() {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Resolver turns a bearer token into the principal it authenticates.
+type Resolver func(ctx context.Context, token string) (*Principal, error)
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	errKey
+)
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns the principal of the request, or nil for anonymous
+// callers.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// HTTPToContext resolves the request's bearer token, if any, into the
+// principal stored in the context. Requests without an Authorization header
+// carry on anonymously, while those whose token is malformed, unknown or
+// expired are rejected by Authorize.
+func HTTPToContext(resolve Resolver) kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if r.Header.Get("Authorization") == "" || resolve == nil {
+			return ctx
+		}
+
+		token := BearerToken(r)
+		if token == "" {
+			return context.WithValue(ctx, errKey,
+				ErrUnauthenticated.Withf("Authorization must be a bearer token"))
+		}
+
+		p, err := resolve(ctx, token)
+		if err == nil && p == nil {
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			return context.WithValue(ctx, errKey, err)
+		}
+		return NewContext(ctx, p)
+	}
+}
+
+// BearerToken extracts the token from the request's Authorization header, or
+// returns an empty string if there is none.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
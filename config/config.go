@@ -0,0 +1,361 @@
+// Package config gathers the server's settings from, in increasing order of
+// precedence, built-in defaults, a YAML file, environment variables and
+// command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/angelcaban/mud/cors"
+	"github.com/angelcaban/mud/health"
+	"github.com/angelcaban/mud/ratelimit"
+	"github.com/angelcaban/mud/registration"
+	"github.com/angelcaban/mud/session"
+	"github.com/angelcaban/mud/storage"
+	"github.com/gofrs/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable holding a setting.
+// The rest is the flag name in upper case with dots and dashes turned into
+// underscores, so -db.max-open-conns is read from MUD_DB_MAX_OPEN_CONNS.
+const EnvPrefix = "MUD_"
+
+var ErrInvalid = errors.New("Invalid Configuration")
+
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Retention RetentionConfig `yaml:"retention"`
+	Database  DatabaseConfig  `yaml:"db"`
+	Password  PasswordConfig  `yaml:"password"`
+	Mail      MailConfig      `yaml:"mail"`
+	Session   SessionConfig   `yaml:"session"`
+	Health    HealthConfig    `yaml:"health"`
+	CORS      CORSConfig      `yaml:"cors"`
+	RateLimit RateLimitConfig `yaml:"ratelimit"`
+}
+
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	LegacyRoutes bool          `yaml:"legacy_routes"`
+}
+
+type ShutdownConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type AuthConfig struct {
+	Admins []string `yaml:"admins"`
+}
+
+type RetentionConfig struct {
+	Deleted  time.Duration `yaml:"deleted"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type PasswordConfig struct {
+	Algorithm    string `yaml:"algorithm"`
+	BcryptCost   int    `yaml:"bcrypt_cost"`
+	Argon2Time   uint   `yaml:"argon2_time"`
+	Argon2Memory uint   `yaml:"argon2_memory"`
+}
+
+type MailConfig struct {
+	Driver string `yaml:"driver"`
+	Dir    string `yaml:"dir"`
+}
+
+type SessionConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+type HealthConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// CORSConfig holds the default CORS policy and its overrides by path prefix,
+// which can only be set in the configuration file.
+type CORSConfig struct {
+	cors.Policy `yaml:",inline"`
+	Routes      map[string]cors.Policy `yaml:"routes"`
+}
+
+// RateLimitConfig bounds how often each client may call groups of endpoints.
+type RateLimitConfig struct {
+	Signup       ratelimit.Limit `yaml:"signup"`
+	Login        ratelimit.Limit `yaml:"login"`
+	LoginAccount ratelimit.Limit `yaml:"login_account"`
+	Recovery     ratelimit.Limit `yaml:"recovery"`
+	API          ratelimit.Limit `yaml:"api"`
+}
+
+// Default returns the settings used where nothing else is configured.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:         ":8080",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  2 * time.Minute,
+		},
+		Shutdown: ShutdownConfig{Timeout: 30 * time.Second},
+		Retention: RetentionConfig{
+			Deleted:  registration.DefaultDeletedRetention,
+			Interval: registration.DefaultPurgeInterval,
+		},
+		Database: DatabaseConfig{
+			Driver:  storage.DriverMySQL,
+			TLS:     "false",
+			Timeout: storage.DefaultQueryTimeout,
+		},
+		Password: PasswordConfig{
+			Algorithm:    "argon2id",
+			BcryptCost:   bcrypt.DefaultCost,
+			Argon2Time:   uint(registration.DefaultArgon2idParams.Time),
+			Argon2Memory: uint(registration.DefaultArgon2idParams.Memory),
+		},
+		Mail:    MailConfig{Driver: "file", Dir: "mail"},
+		Session: SessionConfig{TTL: session.DefaultSessionTTL},
+		Health:  HealthConfig{Timeout: health.DefaultCheckTimeout},
+		CORS:    CORSConfig{Policy: cors.DefaultPolicy()},
+		RateLimit: RateLimitConfig{
+			Signup:       ratelimit.Limit{Count: 5, Per: time.Hour},
+			Login:        ratelimit.Limit{Count: 10, Per: time.Minute},
+			LoginAccount: ratelimit.Limit{Count: 30, Per: time.Minute},
+			Recovery:     ratelimit.Limit{Count: 10, Per: time.Hour},
+			API:          ratelimit.Limit{Count: 300, Per: time.Minute},
+		},
+	}
+}
+
+// bind defines a flag on fs for every setting, defaulting to its current
+// value.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.HTTP.Addr, "http.addr", c.HTTP.Addr, "HTTP listen address, also read from PORT")
+	fs.DurationVar(&c.HTTP.ReadTimeout, "http.read-timeout", c.HTTP.ReadTimeout, "Upper bound for reading a request, including its body")
+	fs.DurationVar(&c.HTTP.WriteTimeout, "http.write-timeout", c.HTTP.WriteTimeout, "Upper bound for handling a request and writing its response")
+	fs.DurationVar(&c.HTTP.IdleTimeout, "http.idle-timeout", c.HTTP.IdleTimeout, "How long idle keep-alive connections are kept open")
+	fs.BoolVar(&c.HTTP.LegacyRoutes, "http.legacy-routes", c.HTTP.LegacyRoutes, "Also serve the deprecated /v1/registrations/update and ?id= routes")
+	fs.DurationVar(&c.Shutdown.Timeout, "shutdown.timeout", c.Shutdown.Timeout, "How long in-flight requests and background jobs get to finish on shutdown")
+	fs.Var((*listValue)(&c.Auth.Admins), "auth.admins", "Comma separated ids of accounts that are always administrators")
+	fs.DurationVar(&c.Retention.Deleted, "retention.deleted", c.Retention.Deleted, "How long deleted accounts are kept before being purged, 0 to keep them forever")
+	fs.DurationVar(&c.Retention.Interval, "retention.interval", c.Retention.Interval, "How often deleted accounts are purged")
+	fs.StringVar(&c.Database.Driver, "db.driver", c.Database.Driver, "Database backend (mysql, sqlite3 or memory)")
+	fs.StringVar(&c.Database.DSN, "db.dsn", c.Database.DSN, "Data source name for the database, overrides every other db setting but the pool; MySQL always gets parseTime and clientFoundRows")
+	fs.StringVar(&c.Database.Host, "db.host", c.Database.Host, "Host[:port] of the MySQL server, or the path of its unix socket")
+	fs.StringVar(&c.Database.User, "db.user", c.Database.User, "User for the MySQL DB")
+	fs.StringVar(&c.Database.Password, "db.password", c.Database.Password, "Password for the MySQL DB")
+	fs.StringVar(&c.Database.PasswordFile, "db.password-file", c.Database.PasswordFile, "File holding the password for the MySQL DB")
+	fs.StringVar(&c.Database.Name, "db.name", c.Database.Name, "Name of the MySQL DB, or file of the SQLite DB")
+	fs.StringVar(&c.Database.TLS, "db.tls", c.Database.TLS, "TLS mode for MySQL (false, true, skip-verify or preferred)")
+	fs.StringVar(&c.Database.TLSCA, "db.tls-ca", c.Database.TLSCA, "PEM file of the authorities trusted to sign the MySQL server's certificate")
+	fs.IntVar(&c.Database.MaxOpenConns, "db.max-open-conns", c.Database.MaxOpenConns, "Most connections open to the database, 0 for no limit")
+	fs.IntVar(&c.Database.MaxIdleConns, "db.max-idle-conns", c.Database.MaxIdleConns, "Most idle connections kept open, 0 for the driver default")
+	fs.DurationVar(&c.Database.ConnMaxLifetime, "db.conn-max-lifetime", c.Database.ConnMaxLifetime, "How long a connection is reused, 0 for ever")
+	fs.BoolVar(&c.Database.Migrate, "db.migrate", c.Database.Migrate, "Apply pending schema migrations at startup")
+	fs.DurationVar(&c.Database.Timeout, "db.timeout", c.Database.Timeout, "Upper bound for each database query")
+	fs.StringVar(&c.Password.Algorithm, "password.algorithm", c.Password.Algorithm, "Hash algorithm for new passwords (argon2id or bcrypt)")
+	fs.IntVar(&c.Password.BcryptCost, "password.bcrypt.cost", c.Password.BcryptCost, "Cost factor for bcrypt password hashes")
+	fs.UintVar(&c.Password.Argon2Time, "password.argon2.time", c.Password.Argon2Time, "Iterations for argon2id password hashes")
+	fs.UintVar(&c.Password.Argon2Memory, "password.argon2.memory", c.Password.Argon2Memory, "Memory in KiB for argon2id password hashes")
+	fs.StringVar(&c.Mail.Driver, "mail.driver", c.Mail.Driver, "How outgoing mail is delivered (file, or log to write it with its tokens to the log)")
+	fs.StringVar(&c.Mail.Dir, "mail.dir", c.Mail.Dir, "Directory the file driver writes outgoing mail to")
+	fs.DurationVar(&c.Session.TTL, "session.ttl", c.Session.TTL, "Lifetime of issued session tokens")
+	fs.DurationVar(&c.Health.Timeout, "health.timeout", c.Health.Timeout, "Upper bound for each health check")
+	fs.Var((*listValue)(&c.CORS.AllowedOrigins), "cors.allowed-origins", "Comma separated origins browsers may call the API from, * for any, none by default")
+	fs.Var((*listValue)(&c.CORS.AllowedMethods), "cors.allowed-methods", "Comma separated methods cross-origin requests may use")
+	fs.Var((*listValue)(&c.CORS.AllowedHeaders), "cors.allowed-headers", "Comma separated headers cross-origin requests may send")
+	fs.Var((*listValue)(&c.CORS.ExposedHeaders), "cors.exposed-headers", "Comma separated response headers cross-origin callers may read")
+	fs.BoolVar(&c.CORS.AllowCredentials, "cors.allow-credentials", c.CORS.AllowCredentials, "Let cross-origin requests carry credentials, requires explicit origins and is not needed for bearer tokens")
+	fs.DurationVar(&c.CORS.MaxAge, "cors.max-age", c.CORS.MaxAge, "How long browsers may cache preflight responses")
+	fs.Var(&c.RateLimit.Signup, "ratelimit.signup", "Registrations allowed per address, as count/period, 0 for no limit")
+	fs.Var(&c.RateLimit.Login, "ratelimit.login", "Login attempts allowed per address, as count/period, 0 for no limit")
+	fs.Var(&c.RateLimit.LoginAccount, "ratelimit.login-account", "Login attempts allowed per username from all addresses, as count/period, 0 for no limit")
+	fs.Var(&c.RateLimit.Recovery, "ratelimit.recovery", "Email verification and password reset requests allowed per address, as count/period, 0 for no limit")
+	fs.Var(&c.RateLimit.API, "ratelimit.api", "Other registration requests allowed per account or address, as count/period, 0 for no limit")
+}
+
+// Load defines the flags for every setting on fs, parses args with it and
+// returns the resulting configuration once validated. The YAML file is
+// named by -config or MUD_CONFIG.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+
+	var file string
+	fs.StringVar(&file, "config", os.Getenv(EnvPrefix+"CONFIG"), "YAML configuration file")
+	c.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags are parsed first to find the file, but must win over it and the
+	// environment, so they are set again once both are applied
+	flags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	if file != "" {
+		if err := c.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(f.Name)
+		if !ok || f.Name == "config" || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%w: %s: %v", ErrInvalid, EnvName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range flags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Database.readSecrets(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+	}
+	return nil
+}
+
+// EnvName returns the environment variable holding the setting of a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(flagName))
+}
+
+func lookupEnv(flagName string) (string, bool) {
+	if value, ok := os.LookupEnv(EnvName(flagName)); ok {
+		return value, true
+	}
+	// PORT is what most platforms set to tell a server where to listen
+	if flagName == "http.addr" {
+		if port := os.Getenv("PORT"); port != "" {
+			return ":" + port, true
+		}
+	}
+	return "", false
+}
+
+// Validate reports the first setting out of range.
+func (c *Config) Validate() error {
+	invalid := func(setting string, format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalid, setting, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case c.HTTP.Addr == "":
+		return invalid("http.addr", "must be set")
+	case c.HTTP.ReadTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0:
+		return invalid("http timeouts", "must not be negative")
+	case c.Shutdown.Timeout <= 0:
+		return invalid("shutdown.timeout", "must be positive")
+	case c.Retention.Deleted < 0:
+		return invalid("retention.deleted", "must not be negative")
+	case c.Retention.Deleted > 0 && c.Retention.Interval <= 0:
+		return invalid("retention.interval", "must be positive")
+	case c.Session.TTL <= 0:
+		return invalid("session.ttl", "must be positive")
+	case c.Health.Timeout < 0:
+		return invalid("health.timeout", "must not be negative")
+	}
+
+	for _, id := range c.Auth.Admins {
+		if _, err := uuid.FromString(id); err != nil {
+			return invalid("auth.admins", "has invalid account id %q", id)
+		}
+	}
+
+	switch c.Password.Algorithm {
+	case "argon2id", "bcrypt":
+	default:
+		return invalid("password.algorithm", "must be argon2id or bcrypt")
+	}
+	if c.Password.BcryptCost < bcrypt.MinCost || c.Password.BcryptCost > bcrypt.MaxCost {
+		return invalid("password.bcrypt.cost", "must be between %d and %d",
+			bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if c.Password.Argon2Time == 0 || c.Password.Argon2Memory == 0 {
+		return invalid("password.argon2", "time and memory must be positive")
+	}
+
+	switch c.Mail.Driver {
+	case "file":
+		if c.Mail.Dir == "" {
+			return invalid("mail.dir", "must be set for mail.driver=file")
+		}
+	case "log":
+	default:
+		return invalid("mail.driver", "must be file or log")
+	}
+
+	if _, err := cors.New(c.CORS.Policy, c.CORS.Routes); err != nil {
+		return invalid("cors", "is unusable: %v", err)
+	}
+
+	return c.Database.validate(invalid)
+}
+
+// AdminIds returns the parsed ids of the accounts that are always
+// administrators.
+func (c *Config) AdminIds() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(c.Auth.Admins))
+	for _, id := range c.Auth.Admins {
+		ids = append(ids, uuid.FromStringOrNil(id))
+	}
+	return ids
+}
+
+// listValue is a flag holding a comma separated list.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/angelcaban/mud/storage"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	defaultSQLiteFile = "mud.db"
+	sqliteParams      = "?_foreign_keys=1&_busy_timeout=5000"
+
+	// Name the custom TLS configuration is registered with the MySQL driver
+	tlsConfigName = "mud"
+)
+
+type DatabaseConfig struct {
+	Driver          string        `yaml:"driver"`
+	DSN             string        `yaml:"dsn"`
+	Host            string        `yaml:"host"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	PasswordFile    string        `yaml:"password_file"`
+	Name            string        `yaml:"name"`
+	TLS             string        `yaml:"tls"`
+	TLSCA           string        `yaml:"tls_ca"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	Migrate         bool          `yaml:"migrate"`
+	Timeout         time.Duration `yaml:"timeout"`
+}
+
+// readSecrets replaces the password with the contents of the password file,
+// if one is configured.
+func (c *DatabaseConfig) readSecrets() error {
+	if c.PasswordFile == "" {
+		return nil
+	}
+	if c.Password != "" {
+		return fmt.Errorf("%w: db.password and db.password-file are exclusive", ErrInvalid)
+	}
+
+	data, err := ioutil.ReadFile(c.PasswordFile)
+	if err != nil {
+		return err
+	}
+	c.Password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+func (c *DatabaseConfig) validate(invalid func(string, string, ...interface{}) error) error {
+	switch c.Driver {
+	case storage.DriverMySQL, storage.DriverSQLite, storage.DriverMemory:
+	default:
+		return invalid("db.driver", "must be mysql, sqlite3 or memory")
+	}
+
+	if c.Driver == storage.DriverMySQL && c.DSN == "" && c.Password != "" && c.User == "" {
+		return invalid("db.password", "requires db.user")
+	}
+	if c.Driver == storage.DriverMySQL && c.DSN != "" {
+		if _, err := mysql.ParseDSN(c.DSN); err != nil {
+			return invalid("db.dsn", "is not a MySQL DSN: %v", err)
+		}
+	}
+
+	switch c.TLS {
+	case "false", "true", "skip-verify", "preferred":
+	default:
+		return invalid("db.tls", "must be false, true, skip-verify or preferred")
+	}
+	if c.TLSCA != "" && c.TLS != "true" {
+		return invalid("db.tls-ca", "requires db.tls=true")
+	}
+
+	switch {
+	case c.MaxOpenConns < 0:
+		return invalid("db.max-open-conns", "must not be negative")
+	case c.MaxIdleConns < 0:
+		return invalid("db.max-idle-conns", "must not be negative")
+	case c.ConnMaxLifetime < 0:
+		return invalid("db.conn-max-lifetime", "must not be negative")
+	case c.Timeout < 0:
+		return invalid("db.timeout", "must not be negative")
+	}
+	return nil
+}
+
+// Pool returns the connection pool settings.
+func (c *DatabaseConfig) Pool() storage.Pool {
+	return storage.Pool{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+	}
+}
+
+// DataSourceName returns the DSN to open the database with, registering the
+// custom TLS configuration with the MySQL driver if one is needed. A MySQL DSN
+// given as is still gets the parameters the repositories rely on.
+func (c *DatabaseConfig) DataSourceName() (string, error) {
+	if c.DSN != "" && c.Driver == storage.DriverMySQL {
+		cfg, err := mysql.ParseDSN(c.DSN)
+		if err != nil {
+			return "", err
+		}
+		cfg.ParseTime = true
+		cfg.ClientFoundRows = true
+		return cfg.FormatDSN(), nil
+	}
+	if c.DSN != "" {
+		return c.DSN, nil
+	}
+
+	if c.Driver == storage.DriverSQLite {
+		name := c.Name
+		if name == "" {
+			name = defaultSQLiteFile
+		}
+		return "file:" + name + sqliteParams, nil
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	cfg.DBName = c.Name
+	cfg.ParseTime = true
+	// Report the rows an UPDATE matched rather than changed
+	cfg.ClientFoundRows = true
+
+	switch {
+	case c.Host == "":
+	case strings.HasPrefix(c.Host, "/"):
+		cfg.Net = "unix"
+		cfg.Addr = c.Host
+	default:
+		cfg.Net = "tcp"
+		cfg.Addr = c.Host
+	}
+
+	if c.TLS != "false" {
+		cfg.TLSConfig = c.TLS
+	}
+	if c.TLSCA != "" {
+		pem, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return "", err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("%w: db.tls-ca holds no certificates", ErrInvalid)
+		}
+
+		serverName := c.Host
+		if host, _, err := net.SplitHostPort(c.Host); err == nil {
+			serverName = host
+		}
+		err = mysql.RegisterTLSConfig(tlsConfigName, &tls.Config{
+			RootCAs:    roots,
+			ServerName: serverName,
+		})
+		if err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = tlsConfigName
+	}
+
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,240 @@
+// Package cors decides which browser origins may call the API, answering
+// preflight requests and decorating responses with the CORS headers.
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCredentialsWithAnyOrigin = errors.New("CORS Credentials Require Explicit Origins")
+	ErrInvalidOrigin            = errors.New("Invalid CORS Origin")
+)
+
+// Policy lists what cross-origin requests may do. An origin is either exact,
+// such as "https://play.example.com", "*" for any origin, or holds a single
+// "*" standing for any subdomain, as in "https://*.example.com".
+type Policy struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// DefaultPolicy lets no origin call the API until some are configured, and
+// then lets them make every request the API serves, without credentials.
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match"},
+		ExposedHeaders: []string{"ETag", "Location", "Retry-After", "WWW-Authenticate"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Validate reports whether the policy can be enforced. Browsers refuse to
+// send credentials to an API allowing any origin, so credentials need every
+// origin spelled out.
+func (p Policy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return ErrCredentialsWithAnyOrigin
+			}
+			continue
+		}
+		if strings.Count(origin, "*") > 1 || !strings.Contains(origin, "://") {
+			return ErrInvalidOrigin
+		}
+	}
+	return nil
+}
+
+// inherit fills the settings an override left empty from the base policy.
+func (p Policy) inherit(base Policy) Policy {
+	if len(p.AllowedOrigins) == 0 {
+		p.AllowedOrigins = base.AllowedOrigins
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = base.AllowedMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = base.AllowedHeaders
+	}
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = base.ExposedHeaders
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = base.MaxAge
+	}
+	return p
+}
+
+func (p Policy) allowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := strings.ToLower(allowed[:i]), strings.ToLower(allowed[i+1:])
+			o := strings.ToLower(origin)
+			if len(o) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(o, prefix) && strings.HasSuffix(o, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := false
+		for _, h := range p.AllowedHeaders {
+			if strings.EqualFold(h, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// CORS applies a default policy to every request, except those whose path
+// starts with a prefix given its own policy.
+type CORS struct {
+	policy    Policy
+	overrides []override
+}
+
+type override struct {
+	prefix string
+	policy Policy
+}
+
+// New returns a CORS applying policy, with overrides keyed by path prefix.
+// Lists and the max age an override leaves empty are taken from policy, but
+// credentials are only allowed where the override says so. The longest
+// matching prefix wins.
+func New(policy Policy, overrides map[string]Policy) (*CORS, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &CORS{policy: policy}
+	for prefix, p := range overrides {
+		p = p.inherit(policy)
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		c.overrides = append(c.overrides, override{prefix, p})
+	}
+	sort.Slice(c.overrides, func(i, j int) bool {
+		return len(c.overrides[i].prefix) > len(c.overrides[j].prefix)
+	})
+	return c, nil
+}
+
+func (c *CORS) policyFor(path string) Policy {
+	for _, o := range c.overrides {
+		if strings.HasPrefix(path, o.prefix) {
+			return o.policy
+		}
+	}
+	return c.policy
+}
+
+// Handler answers preflight requests itself and passes every other request on
+// to next, adding the CORS headers for allowed origins.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p := c.policyFor(r.URL.Path)
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !p.allowsOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p.allowsAnyOrigin() && !p.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(p.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !p.allowsMethod(r.Header.Get("Access-Control-Request-Method")) ||
+			!p.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if len(p.AllowedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		}
+		if p.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
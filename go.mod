@@ -9,5 +9,8 @@ require (
 	github.com/go-sql-driver/mysql v1.4.0
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/gorilla/mux v1.7.4
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/prometheus/client_golang v1.7.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	gopkg.in/yaml.v2 v2.2.5
 )
@@ -0,0 +1,165 @@
+// Package health reports whether the server and the services it depends on
+// are working, for orchestrators deciding when to restart it or route traffic
+// to it.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// DefaultCheckTimeout bounds each check unless configured otherwise.
+const DefaultCheckTimeout = 2 * time.Second
+
+// Status values reported for the server and each check
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check reports why a dependency is unhealthy, or nil if it is healthy.
+type Check func(ctx context.Context) error
+
+// Checker runs a set of named checks, all of which must pass for the server to
+// be healthy.
+type Checker struct {
+	timeout time.Duration
+	logger  log.Logger
+	mtx     sync.RWMutex
+	names   []string
+	checks  map[string]Check
+}
+
+// NewChecker returns a Checker giving each check up to timeout to complete.
+// Why a check failed is only written to logger, never to the report, as it
+// may reveal details of the infrastructure.
+func NewChecker(timeout time.Duration, logger log.Logger) *Checker {
+	return &Checker{
+		timeout: timeout,
+		logger:  logger,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Register adds a check, replacing any previous check of the same name.
+func (c *Checker) Register(name string, check Check) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, exists := c.checks[name]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Report is the outcome of running every check.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Status string `json:"status"`
+}
+
+// Run runs every check concurrently and reports their results.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mtx.RLock()
+	names := append([]string(nil), c.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mtx.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.run(ctx, names[i], checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, name string, check Check) CheckResult {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	begin := time.Now()
+	if err := check(ctx); err != nil {
+		c.logger.Log("msg", "health check failed", "check", name,
+			"took", time.Since(begin), "err", err)
+		return CheckResult{Status: StatusFail}
+	}
+	return CheckResult{Status: StatusOK}
+}
+
+// ServeHTTP answers with the report of every check as JSON, with status 200
+// if all passed and 503 otherwise.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+var ErrNoHeartbeat = errors.New("No Heartbeat")
+
+// Heartbeat tracks the liveness of a loop that is expected to beat regularly,
+// such as the game loop.
+type Heartbeat struct {
+	last int64
+}
+
+// NewHeartbeat returns a Heartbeat whose first beat is now.
+func NewHeartbeat() *Heartbeat {
+	h := &Heartbeat{}
+	h.Beat()
+	return h
+}
+
+// Beat records that the loop is alive.
+func (h *Heartbeat) Beat() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// Check fails once the loop has not beaten for longer than maxAge.
+func (h *Heartbeat) Check(maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		age := time.Since(time.Unix(0, atomic.LoadInt64(&h.last)))
+		if age > maxAge {
+			return fmt.Errorf("%w for %v", ErrNoHeartbeat, age.Truncate(time.Millisecond))
+		}
+		return nil
+	}
+}
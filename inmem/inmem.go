@@ -0,0 +1,387 @@
+// Package inmem provides in-memory implementations of the repositories, for
+// running the server without a database and for tests.
+package inmem
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelcaban/mud/audit"
+	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/registration"
+	"github.com/angelcaban/mud/session"
+	"github.com/angelcaban/mud/storage"
+	"github.com/gofrs/uuid"
+)
+
+type transactor struct{}
+
+// NewTransactor returns a storage.Transactor for the in-memory repositories.
+// They cannot roll back, so units of work are not atomic: one failing midway,
+// say on recording its audit entry, keeps the changes made before it failed.
+// The memory driver is only meant for development.
+func NewTransactor() storage.Transactor {
+	return transactor{}
+}
+
+func (transactor) Within(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type registrationRepository struct {
+	mtx           sync.RWMutex
+	registrations map[uuid.UUID]*model.Registration
+}
+
+func NewRegistrationRepository() registration.RegistrationRepository {
+	return &registrationRepository{
+		registrations: make(map[uuid.UUID]*model.Registration),
+	}
+}
+
+func (r *registrationRepository) Create(ctx context.Context, reg *model.Registration) (*model.Registration, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, exists := r.registrations[reg.Id]; exists {
+		return nil, registration.ErrRegistrationExists
+	}
+	if r.taken(reg) {
+		return nil, registration.ErrRegistrationExists
+	}
+
+	reg.Version = 1
+	r.registrations[reg.Id] = copyRegistration(reg)
+	return reg, nil
+}
+
+func (r *registrationRepository) Update(ctx context.Context, reg *model.Registration) (*model.Registration, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	stored, exists := r.registrations[reg.Id]
+	switch {
+	case !exists:
+		return nil, registration.ErrRegistrationNotFound.Withf("for id %v", reg.Id)
+	case stored.Version != reg.Version:
+		return nil, registration.ErrVersionConflict
+	case r.taken(reg):
+		return nil, registration.ErrRegistrationExists
+	}
+
+	reg.Version++
+	r.registrations[reg.Id] = copyRegistration(reg)
+	return reg, nil
+}
+
+func (r *registrationRepository) Upsert(ctx context.Context, reg *model.Registration) (*model.Registration, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.taken(reg) {
+		return nil, registration.ErrRegistrationExists
+	}
+
+	reg.Version = 1
+	if stored, exists := r.registrations[reg.Id]; exists {
+		reg.Version = stored.Version + 1
+	}
+	r.registrations[reg.Id] = copyRegistration(reg)
+	return reg, nil
+}
+
+// taken reports whether another registration has reg's username or email.
+func (r *registrationRepository) taken(reg *model.Registration) bool {
+	for id, other := range r.registrations {
+		if id == reg.Id {
+			continue
+		}
+		if strings.EqualFold(other.Name, reg.Name) || strings.EqualFold(other.Email, reg.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *registrationRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *registrationRepository) Find(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if reg, ok := r.registrations[id]; ok && reg.Status == model.StatusActive {
+		return copyRegistration(reg), nil
+	}
+	return nil, nil
+}
+
+func (r *registrationRepository) FindAny(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if reg, ok := r.registrations[id]; ok {
+		return copyRegistration(reg), nil
+	}
+	return nil, nil
+}
+
+func (r *registrationRepository) FindByName(ctx context.Context, name string) (*model.Registration, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, reg := range r.registrations {
+		if reg.Status == model.StatusActive && strings.EqualFold(reg.Name, name) {
+			return copyRegistration(reg), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *registrationRepository) FindByEmail(ctx context.Context, email string) (*model.Registration, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, reg := range r.registrations {
+		if reg.Status == model.StatusActive && strings.EqualFold(reg.Email, email) {
+			return copyRegistration(reg), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *registrationRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	purged := []uuid.UUID{}
+	for id, reg := range r.registrations {
+		if reg.Status == model.StatusDeleted && reg.StatusChangedAt.Valid &&
+			reg.StatusChangedAt.Time.Before(deletedBefore) {
+			delete(r.registrations, id)
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
+}
+
+func (r *registrationRepository) List(ctx context.Context,
+	query registration.RegistrationQuery) ([]*model.Registration, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	// Mirror the case-insensitive collation of the SQL schema
+	key := func(reg *model.Registration) string {
+		return strings.ToLower(registration.SortKey(reg, query.SortBy))
+	}
+	less := func(aKey string, aId uuid.UUID, bKey string, bId uuid.UUID) bool {
+		if aKey != bKey {
+			return aKey < bKey
+		}
+		return aId.String() < bId.String()
+	}
+
+	regs := []*model.Registration{}
+	for _, reg := range r.registrations {
+		if reg.Status != query.Status {
+			continue
+		}
+		if query.Validated != nil && reg.Validated != *query.Validated {
+			continue
+		}
+		if query.EmailDomain != "" &&
+			!strings.HasSuffix(strings.ToLower(reg.Email), "@"+strings.ToLower(query.EmailDomain)) {
+			continue
+		}
+		if query.NamePrefix != "" &&
+			!strings.HasPrefix(strings.ToLower(reg.Name), strings.ToLower(query.NamePrefix)) {
+			continue
+		}
+		if query.After != nil {
+			afterKey := strings.ToLower(query.After.Key)
+			if !query.Descending && !less(afterKey, query.After.Id, key(reg), reg.Id) {
+				continue
+			}
+			if query.Descending && !less(key(reg), reg.Id, afterKey, query.After.Id) {
+				continue
+			}
+		}
+		regs = append(regs, copyRegistration(reg))
+	}
+
+	sort.Slice(regs, func(i, j int) bool {
+		if query.Descending {
+			return less(key(regs[j]), regs[j].Id, key(regs[i]), regs[i].Id)
+		}
+		return less(key(regs[i]), regs[i].Id, key(regs[j]), regs[j].Id)
+	})
+
+	if query.Limit > 0 && len(regs) > query.Limit {
+		regs = regs[:query.Limit]
+	}
+	return regs, nil
+}
+
+// Callers may modify what they store or get back, so the repository only
+// ever hands out copies of its records.
+func copyRegistration(reg *model.Registration) *model.Registration {
+	c := *reg
+	c.Password = append([]byte(nil), reg.Password...)
+	return &c
+}
+
+type tokenRepository struct {
+	mtx    sync.RWMutex
+	tokens map[uuid.UUID]*model.AccountToken
+}
+
+func NewTokenRepository() registration.TokenRepository {
+	return &tokenRepository{
+		tokens: make(map[uuid.UUID]*model.AccountToken),
+	}
+}
+
+func (r *tokenRepository) Store(ctx context.Context, token *model.AccountToken) (*model.AccountToken, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := *token
+	r.tokens[token.Id] = &c
+	return token, nil
+}
+
+func (r *tokenRepository) FindByTokenHash(ctx context.Context, purpose string, tokenHash []byte) (*model.AccountToken, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, token := range r.tokens {
+		if token.Purpose == purpose && string(token.TokenHash) == string(tokenHash) {
+			c := *token
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *tokenRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.tokens, id)
+	return nil
+}
+
+func (r *tokenRepository) DeleteByAccount(ctx context.Context, accountId uuid.UUID, purpose string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for id, token := range r.tokens {
+		if token.AccountId == accountId && token.Purpose == purpose {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
+
+type sessionRepository struct {
+	mtx      sync.RWMutex
+	sessions map[uuid.UUID]*model.Session
+}
+
+func NewSessionRepository() session.SessionRepository {
+	return &sessionRepository{
+		sessions: make(map[uuid.UUID]*model.Session),
+	}
+}
+
+func (r *sessionRepository) Store(ctx context.Context, s *model.Session) (*model.Session, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := *s
+	r.sessions[s.Id] = &c
+	return s, nil
+}
+
+func (r *sessionRepository) FindByTokenHash(ctx context.Context, tokenHash []byte) (*model.Session, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, s := range r.sessions {
+		if string(s.TokenHash) == string(tokenHash) {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *sessionRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *sessionRepository) DeleteByAccount(ctx context.Context, accountId uuid.UUID) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for id, s := range r.sessions {
+		if s.AccountId == accountId {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+type auditRepository struct {
+	mtx     sync.RWMutex
+	entries []*model.AuditEntry
+}
+
+func NewAuditRepository() audit.Repository {
+	return &auditRepository{}
+}
+
+func (r *auditRepository) Store(ctx context.Context, entry *model.AuditEntry) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := *entry
+	r.entries = append(r.entries, &c)
+	return nil
+}
+
+func (r *auditRepository) List(ctx context.Context, query audit.Query) ([]*model.AuditEntry, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	// Entries are appended in the order they happen, so walk them backwards
+	entries := []*model.AuditEntry{}
+	for i := len(r.entries) - 1; i >= 0 && len(entries) < query.Limit; i-- {
+		entry := r.entries[i]
+		if query.TargetId != uuid.Nil && entry.TargetId != query.TargetId {
+			continue
+		}
+		if query.ActorId != uuid.Nil && (!entry.ActorId.Valid || entry.ActorId.UUID != query.ActorId) {
+			continue
+		}
+		if query.Action != "" && entry.Action != query.Action {
+			continue
+		}
+		if query.Before != nil && !entry.CreatedAt.Before(query.Before.CreatedAt) &&
+			!(entry.CreatedAt.Equal(query.Before.CreatedAt) &&
+				entry.Id.String() < query.Before.Id.String()) {
+			continue
+		}
+		c := *entry
+		entries = append(entries, &c)
+	}
+	return entries, nil
+}
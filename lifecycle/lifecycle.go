@@ -0,0 +1,85 @@
+// Package lifecycle runs the long lived parts of the server and stops them in
+// order when it shuts down.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Manager stops the subsystems registered with it in the reverse order of
+// registration, so whatever is started last, typically the listeners, is
+// stopped first and whatever everything else depends on, such as the
+// database, is closed last.
+type Manager struct {
+	logger log.Logger
+	mtx    sync.Mutex
+	hooks  []hook
+}
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+func New(logger log.Logger) *Manager {
+	return &Manager{logger: logger}
+}
+
+// OnShutdown registers stop to be called, under the name of its subsystem,
+// when the manager shuts down.
+func (m *Manager) OnShutdown(name string, stop func(ctx context.Context) error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.hooks = append(m.hooks, hook{name, stop})
+}
+
+// Go runs a background job until shutdown, when its context is cancelled and
+// the manager waits for it to return.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	m.OnShutdown(name, func(ctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// Shutdown stops every registered subsystem, last registered first, giving
+// them until ctx is done to finish. A subsystem failing to stop does not keep
+// the others running; the first error is returned. Subsystems are only ever
+// stopped once.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mtx.Lock()
+	hooks := m.hooks
+	m.hooks = nil
+	m.mtx.Unlock()
+
+	var first error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		begin := time.Now()
+		err := hooks[i].stop(ctx)
+		m.logger.Log(
+			"msg", "stopped",
+			"subsystem", hooks[i].name,
+			"took", time.Since(begin),
+			"err", err)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
@@ -15,15 +16,18 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics/prometheus"
 
+	"github.com/angelcaban/mud/audit"
+	"github.com/angelcaban/mud/config"
+	"github.com/angelcaban/mud/cors"
+	"github.com/angelcaban/mud/health"
+	"github.com/angelcaban/mud/inmem"
+	"github.com/angelcaban/mud/lifecycle"
+	"github.com/angelcaban/mud/migrate"
+	"github.com/angelcaban/mud/notify"
+	"github.com/angelcaban/mud/ratelimit"
 	"github.com/angelcaban/mud/registration"
-
-	_ "github.com/go-sql-driver/mysql"
-)
-
-const (
-	defaultPort = "8080"
-	dbDriver    = "mysql"
-	dbConn      = "/"
+	"github.com/angelcaban/mud/session"
+	"github.com/angelcaban/mud/storage"
 )
 
 func main() {
@@ -32,48 +36,187 @@ func main() {
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
-	// Set up variables to init the application
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] [migrate up|down [steps]|status]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	// Load the configuration, flags overriding environment variables
+	// overriding the configuration file
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		logger.Log("Load Configuration Failed", err)
+		os.Exit(2)
+	}
+
+	command := flag.Arg(0)
+	if command != "" && command != "migrate" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Subsystems register with the lifecycle as they start and are stopped in
+	// reverse order when main returns
+	lc := lifecycle.New(log.With(logger, "component", "lifecycle"))
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+		defer cancel()
+		if err := lc.Shutdown(ctx); err != nil {
+			logger.Log("msg", "shutdown incomplete", "err", err)
+		}
+	}()
+
 	var (
-		addr         = envString("PORT", defaultPort)
-		httpAddr     = flag.String("http.addr", ":"+addr, "HTTP listen address")
-		databaseUser = flag.String("db.user", "", "User for the MySQL DB")
-		databasePass = flag.String("db.password", "", "Password for the MySQL DB")
-		databaseName = flag.String("db.name", "", "Name of the MySQL DB")
+		registrationRepo registration.RegistrationRepository
+		tokenRepo        registration.TokenRepository
+		sessionRepo      session.SessionRepository
+		auditRepo        audit.Repository
+		transactor       storage.Transactor
 	)
 
-	flag.Parse()
+	// Liveness only fails when restarting the server would help, readiness
+	// whenever it cannot serve requests
+	healthLogger := log.With(logger, "component", "health")
+	liveness := health.NewChecker(cfg.Health.Timeout, healthLogger)
+	readiness := health.NewChecker(cfg.Health.Timeout, healthLogger)
 
-	// Resolve the database connection and open
-	dsn := ""
-	if databaseUser != nil && *databaseUser != "" {
-		dsn += *databaseUser
-		if databasePass != nil && *databasePass != "" {
-			dsn += ":" + *databasePass + "@"
+	// Create all Repositories
+	switch cfg.Database.Driver {
+	case storage.DriverMemory:
+		if command == "migrate" {
+			logger.Log("Migrate Failed", "the memory driver has no schema")
+			return
 		}
-	}
-	dsn += dbConn
-	if databaseName != nil && *databaseName != "" {
-		dsn += *databaseName
-	}
-	db, err := sql.Open(dbDriver, dsn)
-	if err != nil {
-		logger.Log(fmt.Sprintf("Open Database %q : %q Failed", dbDriver, dbConn), err)
+
+		logger.Log("msg", "the memory driver keeps nothing and cannot roll back failed changes, only use it for development")
+
+		registrationRepo = inmem.NewRegistrationRepository()
+		tokenRepo = inmem.NewTokenRepository()
+		sessionRepo = inmem.NewSessionRepository()
+		auditRepo = inmem.NewAuditRepository()
+		transactor = inmem.NewTransactor()
+
+	case storage.DriverMySQL, storage.DriverSQLite:
+		dsn, err := cfg.Database.DataSourceName()
+		if err != nil {
+			logger.Log("Configure Database Failed", err)
+			return
+		}
+		db, err := storage.Open(cfg.Database.Driver, dsn, cfg.Database.Pool())
+		if err != nil {
+			logger.Log(fmt.Sprintf("Open Database %q Failed", cfg.Database.Driver), err)
+			return
+		}
+
+		lc.OnShutdown("database", func(context.Context) error {
+			return db.Close()
+		})
+
+		if command == "migrate" {
+			if err := runMigrations(logger, migrate.New(db, cfg.Database.Driver),
+				flag.Args()[1:]); err != nil {
+				logger.Log("Migrate Failed", err)
+			}
+			return
+		}
+
+		if cfg.Database.Migrate {
+			if err := runMigrations(logger, migrate.New(db, cfg.Database.Driver),
+				[]string{"up"}); err != nil {
+				logger.Log("Migrate Failed", err)
+				return
+			}
+		}
+
+		registrationRepo, err = registration.NewRegistrationRepository(db, cfg.Database.Driver, cfg.Database.Timeout)
+		if err != nil {
+			logger.Log("Create Registration Repository Failed", err)
+			return
+		}
+
+		sessionRepo, err = session.NewSessionRepository(db, cfg.Database.Driver, cfg.Database.Timeout)
+		if err != nil {
+			logger.Log("Create Session Repository Failed", err)
+			return
+		}
+
+		tokenRepo, err = registration.NewTokenRepository(db, cfg.Database.Driver, cfg.Database.Timeout)
+		if err != nil {
+			logger.Log("Create Token Repository Failed", err)
+			return
+		}
+
+		auditRepo, err = audit.NewRepository(db, cfg.Database.Driver, cfg.Database.Timeout)
+		if err != nil {
+			logger.Log("Create Audit Repository Failed", err)
+			return
+		}
+
+		transactor = storage.NewTransactor(db)
+
+		migrator := migrate.New(db, cfg.Database.Driver)
+		readiness.Register("migrations", func(ctx context.Context) error {
+			pending, err := migrator.Pending(ctx)
+			if err != nil {
+				return err
+			}
+			if pending > 0 {
+				return fmt.Errorf("%d migrations pending", pending)
+			}
+			return nil
+		})
+
+	default:
+		logger.Log("Unknown Database Driver", cfg.Database.Driver)
 		return
 	}
 
-	defer db.Close()
+	// A game loop registers its health.Heartbeat check with liveness
+	readiness.Register("database", registrationRepo.Ping)
 
-	// Create all Repositories
-	registrationRepo, err := registration.NewRegistrationRepository(db, dbDriver)
-	if err != nil {
-		logger.Log("Create Registration Repository Failed", err)
+	// Outgoing mail is only written locally until a real mailer exists
+	var mailer notify.Mailer
+	switch cfg.Mail.Driver {
+	case "log":
+		// Messages hold verification and reset tokens, which must not end
+		// up in a production log
+		logger.Log("msg", "writing outgoing mail to the log, never use mail.driver=log in production")
+		mailer = notify.NewLogMailer(log.With(logger, "component", "mail"))
+	default:
+		mailer, err = notify.NewFileMailer(cfg.Mail.Dir)
+		if err != nil {
+			logger.Log("Create File Mailer Failed", err)
+			return
+		}
+	}
+
+	// Hash new passwords with the configured algorithm while still accepting
+	// every other supported one, so hashes get upgraded on the next login
+	argon2Params := registration.DefaultArgon2idParams
+	argon2Params.Time = uint32(cfg.Password.Argon2Time)
+	argon2Params.Memory = uint32(cfg.Password.Argon2Memory)
+	argon2Hasher := registration.NewArgon2idHasher(argon2Params)
+	bcryptHasher := registration.NewBcryptHasher(cfg.Password.BcryptCost)
+
+	var passwordHasher registration.PasswordHasher
+	switch cfg.Password.Algorithm {
+	case "argon2id":
+		passwordHasher = registration.NewPasswordPolicy(argon2Hasher, bcryptHasher)
+	case "bcrypt":
+		passwordHasher = registration.NewPasswordPolicy(bcryptHasher, argon2Hasher)
+	default:
+		logger.Log("Unknown Password Algorithm", cfg.Password.Algorithm)
 		return
 	}
 
 	fieldKeys := []string{"method"}
 
 	// Create Registration Service Stack
-	registrationService := registration.NewService(registrationRepo)
+	auditService := audit.NewService(auditRepo)
+	registrationService := registration.NewService(registrationRepo, tokenRepo,
+		passwordHasher, mailer, sessionRepo, auditService, transactor,
+		log.With(logger, "component", "registration"))
 	registrationService = registration.NewLoggingService(logger, registrationService)
 	registrationService = registration.NewInstrumentationService(
 		prometheus.NewCounterFrom(stdprometheus.CounterOpts{
@@ -91,52 +234,140 @@ func main() {
 		registrationService,
 	)
 
+	// Create Session Service Stack
+	sessionService := session.NewService(sessionRepo, registrationService, cfg.Session.TTL)
+	sessionService = session.NewLoggingService(logger, sessionService)
+	sessionService = session.NewInstrumentationService(
+		prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "api",
+			Subsystem: "session_service",
+			Name:      "request_count",
+			Help:      "Number of received requests.",
+		}, fieldKeys),
+		prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+			Namespace: "api",
+			Subsystem: "session_service",
+			Name:      "request_latency_microseconds",
+			Help:      "Elapsed time to complete request (in microseconds).",
+		}, fieldKeys),
+		sessionService,
+	)
+
+	if cfg.Retention.Deleted > 0 {
+		lc.Go("purger", func(ctx context.Context) {
+			registration.RunPurger(ctx, registrationService, cfg.Retention.Deleted,
+				cfg.Retention.Interval, log.With(logger, "component", "purger"))
+		})
+	}
+
 	// Create a logger for HTTP events
 	httpLogger := log.With(logger, "component", "http")
 
 	// Create a local server to handle incoming REST Endpoints
 	mux := http.NewServeMux()
-	mux.Handle("/v1/registrations", registration.MakeHandler(registrationService,
-		httpLogger))
+	resolver := session.NewResolver(sessionService, registrationService, cfg.AdminIds()...)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(),
+		log.With(logger, "component", "ratelimit"))
+	registrationHandler := registration.MakeHandler(registrationService, httpLogger,
+		resolver, limiter, registration.RateLimits{
+			Signup:   cfg.RateLimit.Signup,
+			Recovery: cfg.RateLimit.Recovery,
+			API:      cfg.RateLimit.API,
+		}, cfg.HTTP.LegacyRoutes)
+	mux.Handle("/v1/registrations", registrationHandler)
+	mux.Handle("/v1/registrations/", registrationHandler)
+	mux.Handle("/v1/sessions", session.MakeHandler(sessionService, httpLogger, limiter,
+		cfg.RateLimit.Login, cfg.RateLimit.LoginAccount))
+	mux.Handle("/v1/audit", audit.MakeHandler(auditService, httpLogger, resolver))
+
+	// Only let browsers call the API from the configured origins
+	corsPolicy, err := cors.New(cfg.CORS.Policy, cfg.CORS.Routes)
+	if err != nil {
+		logger.Log("Create CORS Policy Failed", err)
+		return
+	}
 
 	// Define default locations
-	http.Handle("/", accessControl(mux))
-	http.Handle("/metrics", promhttp.Handler())
+	root := http.NewServeMux()
+	root.Handle("/", corsPolicy.Handler(mux))
+	root.Handle("/metrics", promhttp.Handler())
+	root.Handle("/healthz", liveness)
+	root.Handle("/readyz", readiness)
+
+	server := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           root,
+		ReadHeaderTimeout: cfg.HTTP.ReadTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
 
 	errs := make(chan error, 2)
 	// Asynchronously run the server
 	go func() {
-		logger.Log("transport", "http", "address", *httpAddr, "msg", "listening")
-		errs <- http.ListenAndServe(*httpAddr, nil)
+		logger.Log("transport", "http", "address", cfg.HTTP.Addr, "msg", "listening")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- err
+		}
 	}()
-	// Asynchronously listen for CTRL+C
+	// Stop accepting requests first, letting in-flight ones finish
+	lc.OnShutdown("http", server.Shutdown)
+
+	// Asynchronously listen for CTRL+C and termination requests. A second
+	// signal while draining kills the process without waiting.
 	go func() {
-		c := make(chan os.Signal)
-		signal.Notify(c, syscall.SIGINT)
-		errs <- fmt.Errorf("%s", <-c)
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-c
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+		errs <- fmt.Errorf("%s", sig)
 	}()
 
 	logger.Log("terminated", <-errs)
 }
 
-func envString(env, fallback string) string {
-	e := os.Getenv(env)
-	if e == "" {
-		return fallback
+// runMigrations carries out a migrate subcommand: "up" applies every pending
+// migration, "down [steps]" reverts the latest ones (one by default) and
+// "status" lists them all.
+func runMigrations(logger log.Logger, migrator *migrate.Migrator, args []string) error {
+	logger = log.With(logger, "component", "migrate")
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
 	}
-	return e
-}
 
-func accessControl(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+	switch action {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			logger.Log("msg", "applied", "version", m.Version, "name", m.Name)
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrator.Down(steps)
+		for _, m := range reverted {
+			logger.Log("msg", "reverted", "version", m.Version, "name", m.Name)
+		}
+		return err
 
-		if r.Method == "OPTIONS" {
-			return
+	case "status":
+		status, err := migrator.Status()
+		for _, m := range status {
+			logger.Log("version", m.Version, "name", m.Name, "applied", m.Applied)
 		}
+		return err
+	}
 
-		h.ServeHTTP(w, r)
-	})
+	return fmt.Errorf("unknown migrate action %q", action)
 }
@@ -0,0 +1,232 @@
+// Package migrate keeps the SQL schema up to date. Migrations are compiled
+// into the binary, applied in order and recorded in the schema_migrations
+// table so each one only ever runs once per database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/angelcaban/mud/storage"
+)
+
+const (
+	MIGRATIONS_TABLE = "schema_migrations"
+)
+
+var ErrUnsupportedDriver = errors.New("No Migrations For Driver")
+var ErrUnknownVersion = errors.New("Database Has Unknown Migration Version")
+
+// Migration is one versioned change to the schema, given as SQL statements
+// for every supported driver.
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// New creates a Migrator running the built-in migrations against db.
+func New(db *sql.DB, driver string) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:         db,
+		driver:     driver,
+		migrations: sorted,
+	}
+}
+
+// Up applies every pending migration in order, returning those it applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range m.migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		statements, ok := migration.Up[m.driver]
+		if !ok {
+			return done, ErrUnsupportedDriver
+		}
+
+		err := m.run(statements, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO "+MIGRATIONS_TABLE+
+				" (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", migration.Version,
+				migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts up to steps of the most recently applied migrations, returning
+// those it reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+
+		statements, ok := migration.Down[m.driver]
+		if !ok {
+			return done, ErrUnsupportedDriver
+		}
+
+		err := m.run(statements, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM "+MIGRATIONS_TABLE+" WHERE version = ?",
+				migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", migration.Version,
+				migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i] = MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: applied[migration.Version],
+		}
+	}
+	return status, nil
+}
+
+// Pending returns how many known migrations have not been applied yet. Unlike
+// the other methods it never changes the schema, so a database without the
+// bookkeeping table simply has every migration pending.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	applied, err := m.appliedVersions(ctx)
+	if storage.IsMissingTable(err) {
+		return len(m.migrations), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// run executes a migration's statements together with its bookkeeping. They
+// share a transaction, although MySQL commits DDL statements implicitly.
+func (m *Migrator) run(statements []string, record func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applied returns the set of recorded migration versions, creating the
+// bookkeeping table on first use.
+func (m *Migrator) applied() (map[int]bool, error) {
+	_, err := m.db.Exec("CREATE TABLE IF NOT EXISTS " + MIGRATIONS_TABLE + ` (
+  version INTEGER NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at DATETIME NOT NULL)`)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.appliedVersions(context.Background())
+}
+
+// appliedVersions reads the set of recorded migration versions, failing if
+// the bookkeeping table does not exist.
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version FROM "+MIGRATIONS_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[int]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		if !known[version] {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,252 @@
+package migrate
+
+import (
+	"github.com/angelcaban/mud/storage"
+)
+
+// migrations lists every schema change. Never edit a migration once it has
+// been released; add a new one instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_registrations",
+		Up: map[string][]string{
+			storage.DriverMySQL: {`
+CREATE TABLE registrations (
+  id CHAR(36) NOT NULL,
+  name VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  email VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+  timezone VARCHAR(45) NOT NULL,
+  password VARBINARY(256) NOT NULL,
+  shortbio LONGTEXT NULL,
+  validated TINYINT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY registrations_name (name),
+  UNIQUE KEY registrations_email (email))
+  DEFAULT CHARSET = utf8mb4`,
+			},
+			storage.DriverSQLite: {`
+CREATE TABLE registrations (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  email VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  timezone VARCHAR(45) NOT NULL,
+  password VARBINARY(256) NOT NULL,
+  shortbio TEXT NULL,
+  validated BOOLEAN NULL)`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL:  {`DROP TABLE registrations`},
+			storage.DriverSQLite: {`DROP TABLE registrations`},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_sessions",
+		Up: map[string][]string{
+			storage.DriverMySQL: {`
+CREATE TABLE sessions (
+  id CHAR(36) NOT NULL,
+  account_id CHAR(36) NOT NULL,
+  token_hash BINARY(32) NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY sessions_token_hash (token_hash),
+  KEY sessions_account_id (account_id))`,
+			},
+			storage.DriverSQLite: {`
+CREATE TABLE sessions (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  account_id CHAR(36) NOT NULL,
+  token_hash BLOB NOT NULL UNIQUE,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL)`,
+				`CREATE INDEX sessions_account_id ON sessions (account_id)`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL:  {`DROP TABLE sessions`},
+			storage.DriverSQLite: {`DROP TABLE sessions`},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_account_tokens",
+		Up: map[string][]string{
+			storage.DriverMySQL: {`
+CREATE TABLE account_tokens (
+  id CHAR(36) NOT NULL,
+  account_id CHAR(36) NOT NULL,
+  purpose VARCHAR(32) NOT NULL,
+  token_hash BINARY(32) NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY account_tokens_token_hash (purpose, token_hash),
+  KEY account_tokens_account_id (account_id, purpose))`,
+			},
+			storage.DriverSQLite: {`
+CREATE TABLE account_tokens (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  account_id CHAR(36) NOT NULL,
+  purpose VARCHAR(32) NOT NULL,
+  token_hash BLOB NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  UNIQUE (purpose, token_hash))`,
+				`CREATE INDEX account_tokens_account_id ON account_tokens (account_id, purpose)`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL:  {`DROP TABLE account_tokens`},
+			storage.DriverSQLite: {`DROP TABLE account_tokens`},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_registration_roles",
+		Up: map[string][]string{
+			storage.DriverMySQL: {
+				`ALTER TABLE registrations ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'player'`,
+			},
+			storage.DriverSQLite: {
+				`ALTER TABLE registrations ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'player'`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL: {`ALTER TABLE registrations DROP COLUMN role`},
+			// The bundled SQLite predates DROP COLUMN, so rebuild the table
+			storage.DriverSQLite: {`
+CREATE TABLE registrations_v3 (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  email VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  timezone VARCHAR(45) NOT NULL,
+  password VARBINARY(256) NOT NULL,
+  shortbio TEXT NULL,
+  validated BOOLEAN NULL)`,
+				`INSERT INTO registrations_v3
+  SELECT id, name, email, timezone, password, shortbio, validated FROM registrations`,
+				`DROP TABLE registrations`,
+				`ALTER TABLE registrations_v3 RENAME TO registrations`,
+			},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_registration_status",
+		Up: map[string][]string{
+			storage.DriverMySQL: {`
+ALTER TABLE registrations
+  ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active',
+  ADD COLUMN status_reason VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN status_changed_at DATETIME NULL,
+  ADD KEY registrations_status (status, status_changed_at)`,
+			},
+			storage.DriverSQLite: {
+				`ALTER TABLE registrations ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active'`,
+				`ALTER TABLE registrations ADD COLUMN status_reason VARCHAR(255) NOT NULL DEFAULT ''`,
+				`ALTER TABLE registrations ADD COLUMN status_changed_at DATETIME NULL`,
+				`CREATE INDEX registrations_status ON registrations (status, status_changed_at)`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL: {`
+ALTER TABLE registrations
+  DROP KEY registrations_status,
+  DROP COLUMN status,
+  DROP COLUMN status_reason,
+  DROP COLUMN status_changed_at`,
+			},
+			storage.DriverSQLite: {`
+CREATE TABLE registrations_v4 (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  email VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  timezone VARCHAR(45) NOT NULL,
+  password VARBINARY(256) NOT NULL,
+  shortbio TEXT NULL,
+  validated BOOLEAN NULL,
+  role VARCHAR(16) NOT NULL DEFAULT 'player')`,
+				`INSERT INTO registrations_v4
+  SELECT id, name, email, timezone, password, shortbio, validated, role FROM registrations`,
+				`DROP TABLE registrations`,
+				`ALTER TABLE registrations_v4 RENAME TO registrations`,
+			},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_audit_log",
+		Up: map[string][]string{
+			storage.DriverMySQL: {`
+CREATE TABLE audit_log (
+  id CHAR(36) NOT NULL,
+  actor_id CHAR(36) NULL,
+  target_id CHAR(36) NOT NULL,
+  action VARCHAR(32) NOT NULL,
+  changes TEXT NOT NULL,
+  source_ip VARCHAR(45) NOT NULL,
+  created_at DATETIME(6) NOT NULL,
+  PRIMARY KEY (id),
+  KEY audit_log_created_at (created_at, id),
+  KEY audit_log_target_id (target_id, created_at),
+  KEY audit_log_actor_id (actor_id, created_at))`,
+			},
+			storage.DriverSQLite: {`
+CREATE TABLE audit_log (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  actor_id CHAR(36) NULL,
+  target_id CHAR(36) NOT NULL,
+  action VARCHAR(32) NOT NULL,
+  changes TEXT NOT NULL,
+  source_ip VARCHAR(45) NOT NULL,
+  created_at DATETIME NOT NULL)`,
+				`CREATE INDEX audit_log_created_at ON audit_log (created_at, id)`,
+				`CREATE INDEX audit_log_target_id ON audit_log (target_id, created_at)`,
+				`CREATE INDEX audit_log_actor_id ON audit_log (actor_id, created_at)`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL:  {`DROP TABLE audit_log`},
+			storage.DriverSQLite: {`DROP TABLE audit_log`},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_registration_version",
+		Up: map[string][]string{
+			storage.DriverMySQL: {
+				`ALTER TABLE registrations ADD COLUMN version BIGINT NOT NULL DEFAULT 1`,
+			},
+			storage.DriverSQLite: {
+				`ALTER TABLE registrations ADD COLUMN version BIGINT NOT NULL DEFAULT 1`,
+			},
+		},
+		Down: map[string][]string{
+			storage.DriverMySQL: {`ALTER TABLE registrations DROP COLUMN version`},
+			storage.DriverSQLite: {`
+CREATE TABLE registrations_v6 (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  email VARCHAR(255) NOT NULL COLLATE NOCASE UNIQUE,
+  timezone VARCHAR(45) NOT NULL,
+  password VARBINARY(256) NOT NULL,
+  shortbio TEXT NULL,
+  validated BOOLEAN NULL,
+  role VARCHAR(16) NOT NULL DEFAULT 'player',
+  status VARCHAR(16) NOT NULL DEFAULT 'active',
+  status_reason VARCHAR(255) NOT NULL DEFAULT '',
+  status_changed_at DATETIME NULL)`,
+				`INSERT INTO registrations_v6
+  SELECT id, name, email, timezone, password, shortbio, validated, role, status,
+    status_reason, status_changed_at FROM registrations`,
+				`DROP TABLE registrations`,
+				`ALTER TABLE registrations_v6 RENAME TO registrations`,
+				`CREATE INDEX registrations_status ON registrations (status, status_changed_at)`,
+			},
+		},
+	},
+}
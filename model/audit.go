@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditActionCreate     = "create"
+	AuditActionEdit       = "edit"
+	AuditActionDelete     = "delete"
+	AuditActionDeactivate = "deactivate"
+	AuditActionRestore    = "restore"
+	AuditActionValidate   = "validate"
+
+	AuditActionPasswordReset = "password_reset"
+	AuditActionPurge         = "purge"
+)
+
+type AuditEntry struct {
+	Id uuid.UUID `stbl:"id, PRIMARY_KEY"`
+
+	// Account that made the change, unset for anonymous callers
+	ActorId uuid.NullUUID `stbl:"actor_id"`
+
+	TargetId uuid.UUID `stbl:"target_id"`
+	Action   string    `stbl:"action"`
+
+	// JSON object of the changed fields and their new values, secrets redacted
+	Changes string `stbl:"changes"`
+
+	SourceIP  string    `stbl:"source_ip"`
+	CreatedAt time.Time `stbl:"created_at"`
+}
@@ -1,9 +1,27 @@
 package model
 
 import (
+	"database/sql"
+
 	"github.com/gofrs/uuid"
 )
 
+// Roles an account can hold, each including the permissions of the previous
+const (
+	RolePlayer  = "player"
+	RoleBuilder = "builder"
+	RoleAdmin   = "admin"
+)
+
+// States an account can be in. Only active accounts can log in or be found
+// by other players.
+const (
+	StatusActive    = "active"
+	StatusDeleted   = "deleted"
+	StatusBanned    = "banned"
+	StatusSuspended = "suspended"
+)
+
 type Registration struct {
 	Id        uuid.UUID `stbl:"id, PRIMARY_KEY"`
 	Name      string    `stbl:"name"`
@@ -12,4 +30,14 @@ type Registration struct {
 	Password  []byte    `stbl:"password"`
 	ShortBio  string    `stbl:"shortbio"`
 	Validated bool      `stbl:"validated"`
+	Role      string    `stbl:"role"`
+
+	Status       string `stbl:"status"`
+	StatusReason string `stbl:"status_reason"`
+
+	// When Status last changed, unset for accounts that were never deactivated
+	StatusChangedAt sql.NullTime `stbl:"status_changed_at"`
+
+	// Incremented on every update, zero for registrations never stored
+	Version int64 `stbl:"version"`
 }
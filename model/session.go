@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type Session struct {
+	Id        uuid.UUID `stbl:"id, PRIMARY_KEY"`
+	AccountId uuid.UUID `stbl:"account_id"`
+	TokenHash []byte    `stbl:"token_hash"`
+	CreatedAt time.Time `stbl:"created_at"`
+	ExpiresAt time.Time `stbl:"expires_at"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Purposes an AccountToken can be issued for
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+type AccountToken struct {
+	Id        uuid.UUID `stbl:"id, PRIMARY_KEY"`
+	AccountId uuid.UUID `stbl:"account_id"`
+	Purpose   string    `stbl:"purpose"`
+	TokenHash []byte    `stbl:"token_hash"`
+	CreatedAt time.Time `stbl:"created_at"`
+	ExpiresAt time.Time `stbl:"expires_at"`
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Message is a single outgoing email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to account holders.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type logMailer struct {
+	logger log.Logger
+}
+
+// NewLogMailer creates a Mailer which writes every message to the logger
+// instead of delivering it. Meant for local development only.
+func NewLogMailer(logger log.Logger) Mailer {
+	return &logMailer{logger}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	return m.logger.Log(
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body)
+}
+
+type fileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates a Mailer which writes every message as a file in the
+// given directory instead of delivering it. Meant for local development only.
+func NewFileMailer(dir string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(),
+		strings.Map(safeFileRune, msg.To))
+	contents := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		msg.To, msg.Subject, msg.Body)
+	return ioutil.WriteFile(filepath.Join(m.dir, name), []byte(contents), 0600)
+}
+
+func safeFileRune(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+		r == '@', r == '.', r == '-', r == '_':
+		return r
+	}
+	return '_'
+}
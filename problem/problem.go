@@ -0,0 +1,78 @@
+// Package problem writes error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/angelcaban/mud/apperr"
+)
+
+// ContentType is the media type of a problem details document.
+const ContentType = "application/problem+json"
+
+// Details is a problem details object. Type defaults to "about:blank", in
+// which case Title is the status text of Status.
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Entry of the apperr code catalogue
+	Code apperr.Code `json:"code,omitempty"`
+
+	// Whether the same request may succeed later
+	Retryable bool `json:"retryable,omitempty"`
+
+	// Request members that failed validation, if any
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+
+	// Sent as the Retry-After header when set
+	RetryAfter time.Duration `json:"-"`
+}
+
+// InvalidParam names a request member and why it was rejected.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// New returns the details of a problem identified only by its status.
+func New(status int, detail string) Details {
+	return Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// FromError describes err by its domain error. The message of internal errors
+// is withheld, their causes are only fit for the logs.
+func FromError(err error) Details {
+	e := apperr.From(err)
+	details := New(e.Status, e.Message)
+	if e.Code == apperr.CodeInternal {
+		details.Detail = ""
+	}
+	details.Code = e.Code
+	details.Retryable = e.Retryable
+	details.RetryAfter = e.RetryAfter
+	return details
+}
+
+// Write sends a problem as the response, using its Status as status code.
+func Write(w http.ResponseWriter, details Details) error {
+	w.Header().Set("Content-Type", ContentType)
+	if details.RetryAfter > 0 {
+		// Round up, retrying early would only be rejected again
+		seconds := (details.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	}
+	w.WriteHeader(details.Status)
+	return json.NewEncoder(w).Encode(details)
+}
@@ -0,0 +1,157 @@
+// Package ratelimit bounds how often clients may call endpoints, drawing a
+// token from a bucket per client for every request.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/auth"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+var ErrRateLimited = apperr.New(apperr.CodeRateLimited,
+	http.StatusTooManyRequests, "Too Many Requests")
+
+// Limit allows Count requests per period of Per. Clients may spend all of
+// them at once, after which they come back evenly over the period. The zero
+// Limit allows everything.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+// Unlimited reports whether the limit allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Count <= 0 || l.Per <= 0
+}
+
+// interval is the time it takes for one token to come back.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Count)
+}
+
+// String formats the limit as Count/Per, such as "5/1h".
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "0"
+	}
+	per := l.Per.String()
+	if strings.HasSuffix(per, "m0s") {
+		per = per[:len(per)-2]
+	}
+	if strings.HasSuffix(per, "h0m") {
+		per = per[:len(per)-2]
+	}
+	return fmt.Sprintf("%d/%s", l.Count, per)
+}
+
+// Set parses a limit formatted as Count/Per, such as "5/1h", or "0" for no
+// limit. It lets limits be used as flags.
+func (l *Limit) Set(value string) error {
+	if value == "0" || value == "" {
+		*l = Limit{}
+		return nil
+	}
+
+	i := strings.Index(value, "/")
+	if i < 0 {
+		return fmt.Errorf("limit %q is not formatted as count/period", value)
+	}
+	count, err := strconv.Atoi(value[:i])
+	if err != nil || count < 0 {
+		return fmt.Errorf("limit %q has an invalid count", value)
+	}
+	per, err := time.ParseDuration(value[i+1:])
+	if err != nil || per < 0 {
+		return fmt.Errorf("limit %q has an invalid period", value)
+	}
+	*l = Limit{Count: count, Per: per}
+	return nil
+}
+
+// UnmarshalText lets limits be read from configuration files.
+func (l *Limit) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
+
+// Store keeps the token buckets. NewMemoryStore keeps them in the process; a
+// store shared between servers, over Redis for example, limits clients across
+// all of them.
+type Store interface {
+	// Take draws a token from the bucket identified by key, which starts out
+	// full. If the bucket is empty it returns how long until a token is back.
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// KeyFunc names the client making a request, or returns an empty string if
+// the request should not be limited.
+type KeyFunc func(ctx context.Context, request interface{}) string
+
+// ByIP keys requests by the address they come from. It needs the request
+// context populated by kithttp.PopulateRequestContext.
+func ByIP(ctx context.Context, _ interface{}) string {
+	addr, _ := ctx.Value(kithttp.ContextKeyRequestRemoteAddr).(string)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if addr == "" {
+		return ""
+	}
+	return "ip:" + addr
+}
+
+// ByAccount keys requests by the authenticated account, or by address for
+// anonymous callers.
+func ByAccount(ctx context.Context, request interface{}) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return "account:" + p.AccountId.String()
+	}
+	return ByIP(ctx, request)
+}
+
+// Limiter builds endpoint middleware on a shared store.
+type Limiter struct {
+	store  Store
+	logger log.Logger
+}
+
+func NewLimiter(store Store, logger log.Logger) *Limiter {
+	return &Limiter{store: store, logger: logger}
+}
+
+// Limit rejects requests with ErrRateLimited once the client named by key
+// exceeds limit on endpoints sharing the same name. Should the store fail,
+// requests are let through rather than locking every client out.
+func (l *Limiter) Limit(name string, limit Limit, key KeyFunc) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if l == nil || limit.Unlimited() {
+			return next
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			k := key(ctx, request)
+			if k == "" {
+				return next(ctx, request)
+			}
+
+			ok, retryAfter, err := l.store.Take(ctx, name+"/"+k, limit)
+			if err != nil {
+				l.logger.Log("msg", "rate limit store failed", "limit", name, "err", err)
+				return next(ctx, request)
+			}
+			if !ok {
+				return nil, ErrRateLimited.After(retryAfter)
+			}
+			return next(ctx, request)
+		}
+	}
+}
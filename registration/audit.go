@@ -0,0 +1,45 @@
+package registration
+
+import (
+	"github.com/angelcaban/mud/audit"
+	"github.com/angelcaban/mud/model"
+)
+
+// createChanges lists the fields of a new registration for the audit log.
+func createChanges(reg *model.Registration) audit.Changes {
+	return audit.Changes{
+		"username": reg.Name,
+		"password": audit.Redacted,
+		"email":    reg.Email,
+		"shortbio": reg.ShortBio,
+		"timezone": reg.TimeZone,
+	}
+}
+
+// editChanges lists the fields a patch set on a registration for the audit
+// log, with their values once stored.
+func editChanges(patch RegistrationPatch, reg *model.Registration) audit.Changes {
+	changes := audit.Changes{}
+	if patch.Username != nil {
+		changes["username"] = reg.Name
+	}
+	if patch.Password != nil {
+		changes["password"] = audit.Redacted
+	}
+	if patch.Email != nil {
+		changes["email"] = reg.Email
+	}
+	if patch.ShortBio != nil {
+		changes["shortbio"] = reg.ShortBio
+	}
+	if patch.TimeZone != nil {
+		changes["timezone"] = reg.TimeZone
+	}
+	if patch.Validated != nil {
+		changes["validated"] = reg.Validated
+	}
+	if patch.Role != nil {
+		changes["role"] = reg.Role
+	}
+	return changes
+}
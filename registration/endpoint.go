@@ -2,7 +2,9 @@ package registration
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/angelcaban/mud/auth"
 	"github.com/angelcaban/mud/model"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/gofrs/uuid"
@@ -14,7 +16,6 @@ type NewRegistrationRequest struct {
 	Email       string `json:"email"`
 	TimeZone    string `json:"timezone"`
 	ShortBio    string `json:"shortbio,omitempty"`
-	Validated   bool   `json:"validated,omitempty"`
 }
 
 type NewRegistrationResponse struct {
@@ -29,48 +30,208 @@ type EditRegistrationRequest struct {
 	TimeZone    string    `json:"timezone"`
 	ShortBio    string    `json:"shortbio,omitempty"`
 	Email       string    `json:"email,omitempty"`
-	Validated   bool      `json:"validated,omitempty"`
 }
 
+// PatchRegistrationRequest is what PUT, PATCH and the legacy update route are
+// all decoded into.
+type PatchRegistrationRequest struct {
+	Id    uuid.UUID
+	Patch RegistrationPatch
+}
+
+// ReplaceRegistrationRequest is the body of a PUT, which replaces every field
+// of a registration; omitted fields are reset to their defaults.
+type ReplaceRegistrationRequest struct {
+	Username    string  `json:"username"`
+	PasswordEnc []byte  `json:"password,omitempty"`
+	Email       string  `json:"email"`
+	TimeZone    string  `json:"timezone"`
+	ShortBio    string  `json:"shortbio"`
+	Validated   *bool   `json:"validated,omitempty"`
+	Role        *string `json:"role,omitempty"`
+}
+
+// EditRegistrationResponse is rendered through RegistrationView, never as is.
 type EditRegistrationResponse struct {
-	Id       uuid.UUID `json:"id"`
-	Username string    `json:"username,omitempty"`
-	TimeZone string    `json:"timezone"`
-	ShortBio string    `json:"shortbio,omitempty"`
-	Email    string    `json:"email,omitempty"`
-	Err      error     `json:"error,omitempty"`
+	Registration *model.Registration
+	Err          error
+}
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ConfirmEmailResponse struct {
+	Id  uuid.UUID `json:"id,omitempty"`
+	Err error     `json:"error,omitempty"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type ResendVerificationResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type RequestPasswordResetResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	PasswordEnc []byte `json:"password"`
+}
+
+type ResetPasswordResponse struct {
+	Err error `json:"error,omitempty"`
 }
 
 type RegistrationRequestWithId struct {
 	Id uuid.UUID `json:"id"`
 }
 
+type DeleteRegistrationRequest struct {
+	Id     uuid.UUID
+	Reason string
+}
+
 type DeleteRegistrationResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
+type DeactivateRegistrationRequest struct {
+	Id     uuid.UUID `json:"-"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason"`
+}
+
+type DeactivateRegistrationResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+// RestoreRegistrationResponse is rendered through RegistrationView, never as
+// is.
+type RestoreRegistrationResponse struct {
+	Registration *model.Registration
+	Err          error
+}
+
+// GetRegistrationResponse is rendered through RegistrationView, never as is.
 type GetRegistrationResponse struct {
-	Registration *model.Registration `json:"registration,omitempty"`
-	Err          error               `json:"error,omitempty"`
+	Registration *model.Registration
+	Err          error
+}
+
+type GetAllRegistrationsRequest struct {
+	Options ListOptions
 }
 
+// GetAllRegistrationsResponse is rendered through RegistrationView, never as
+// is.
 type GetAllRegistrationsResponse struct {
-	Registrations []*model.Registration `json:"registrations,omitempty"`
-	Err           error                 `json:"error,omitempty"`
+	Registrations []*model.Registration
+	NextCursor    string
+	Err           error
+}
+
+// registrationDocument is how a single registration is represented, whether
+// it was read or changed by the request.
+type registrationDocument struct {
+	Registration interface{} `json:"registration"`
+}
+
+func (r EditRegistrationResponse) view(p *auth.Principal) interface{} {
+	return registrationDocument{RegistrationView(p, r.Registration)}
+}
+
+func (r EditRegistrationResponse) Headers() http.Header {
+	return http.Header{"ETag": []string{etag(r.Registration.Version)}}
+}
+
+func (r GetRegistrationResponse) Headers() http.Header {
+	return http.Header{"ETag": []string{etag(r.Registration.Version)}}
+}
+
+func (r RestoreRegistrationResponse) view(p *auth.Principal) interface{} {
+	return registrationDocument{RegistrationView(p, r.Registration)}
+}
+
+func (r RestoreRegistrationResponse) Headers() http.Header {
+	return http.Header{"ETag": []string{etag(r.Registration.Version)}}
+}
+
+func (r GetRegistrationResponse) view(p *auth.Principal) interface{} {
+	return registrationDocument{RegistrationView(p, r.Registration)}
+}
+
+func (r GetAllRegistrationsResponse) view(p *auth.Principal) interface{} {
+	views := make([]interface{}, len(r.Registrations))
+	for i, reg := range r.Registrations {
+		views[i] = RegistrationView(p, reg)
+	}
+	return struct {
+		Registrations []interface{} `json:"registrations"`
+		NextCursor    string        `json:"next_cursor,omitempty"`
+	}{views, r.NextCursor}
 }
 
 func (r NewRegistrationResponse) error() error {
 	return r.Err
 }
 
+func (r NewRegistrationResponse) StatusCode() int {
+	return http.StatusCreated
+}
+
+func (r NewRegistrationResponse) Headers() http.Header {
+	return http.Header{"Location": []string{"/v1/registrations/" + r.Id.String()}}
+}
+
 func (r EditRegistrationResponse) error() error {
 	return r.Err
 }
 
+func (r ConfirmEmailResponse) error() error {
+	return r.Err
+}
+
+func (r ResendVerificationResponse) error() error {
+	return r.Err
+}
+
+func (r RequestPasswordResetResponse) error() error {
+	return r.Err
+}
+
+func (r ResetPasswordResponse) error() error {
+	return r.Err
+}
+
 func (r DeleteRegistrationResponse) error() error {
 	return r.Err
 }
 
+func (r DeleteRegistrationResponse) StatusCode() int {
+	return http.StatusNoContent
+}
+
+func (r DeactivateRegistrationResponse) error() error {
+	return r.Err
+}
+
+func (r DeactivateRegistrationResponse) StatusCode() int {
+	return http.StatusNoContent
+}
+
+func (r RestoreRegistrationResponse) error() error {
+	return r.Err
+}
+
 func (r GetRegistrationResponse) error() error {
 	return r.Err
 }
@@ -82,7 +243,7 @@ func (r GetAllRegistrationsResponse) error() error {
 func makeNewRegistrationEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NewRegistrationRequest)
-		reg, err := s.NewRegistration(req.Username, req.PasswordEnc, req.Email,
+		reg, err := s.NewRegistration(ctx, req.Username, req.PasswordEnc, req.Email,
 			req.ShortBio, req.TimeZone)
 		if err != nil {
 			return NewRegistrationResponse{
@@ -95,37 +256,61 @@ func makeNewRegistrationEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-func makeUpdateRegistrationEndpoint(s Service) endpoint.Endpoint {
+func makeEditRegistrationEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(EditRegistrationRequest)
-		reg, err := s.EditRegistration(req.Id, req.Username, req.PasswordEnc,
-			req.Email, req.ShortBio, req.TimeZone, req.Validated)
+		req := request.(PatchRegistrationRequest)
+		reg, err := s.EditRegistration(ctx, req.Id, req.Patch)
 		if err != nil {
-			return EditRegistrationResponse{
-				Id:       uuid.Nil,
-				Username: "",
-				TimeZone: "",
-				ShortBio: "",
-				Email:    "",
-				Err:      err,
+			return EditRegistrationResponse{Err: err}, nil
+		}
+
+		return EditRegistrationResponse{Registration: reg}, nil
+	}
+}
+
+func makeConfirmEmailEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ConfirmEmailRequest)
+		reg, err := s.ConfirmEmail(ctx, req.Token)
+		if err != nil {
+			return ConfirmEmailResponse{
+				Id:  uuid.Nil,
+				Err: err,
 			}, nil
 		}
 
-		return EditRegistrationResponse{
-			Id:       reg.Id,
-			Username: reg.Name,
-			Email:    reg.Email,
-			TimeZone: reg.TimeZone,
-			ShortBio: reg.ShortBio,
-			Err:      nil,
-		}, nil
+		return ConfirmEmailResponse{Id: reg.Id, Err: nil}, nil
+	}
+}
+
+func makeResendVerificationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResendVerificationRequest)
+		err := s.ResendVerification(ctx, req.Email)
+		return ResendVerificationResponse{Err: err}, nil
+	}
+}
+
+func makeRequestPasswordResetEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RequestPasswordResetRequest)
+		err := s.RequestPasswordReset(ctx, req.Email)
+		return RequestPasswordResetResponse{Err: err}, nil
+	}
+}
+
+func makeResetPasswordEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResetPasswordRequest)
+		err := s.ResetPassword(ctx, req.Token, req.PasswordEnc)
+		return ResetPasswordResponse{Err: err}, nil
 	}
 }
 
 func makeDeleteRegistrationEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(RegistrationRequestWithId)
-		err := s.DeleteRegistration(req.Id)
+		req := request.(DeleteRegistrationRequest)
+		err := s.DeleteRegistration(ctx, req.Id, req.Reason)
 		if err != nil {
 			return DeleteRegistrationResponse{
 				Err: err,
@@ -138,17 +323,48 @@ func makeDeleteRegistrationEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
+func makeDeactivateRegistrationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeactivateRegistrationRequest)
+		err := s.DeactivateRegistration(ctx, req.Id, req.Status, req.Reason)
+		return DeactivateRegistrationResponse{Err: err}, nil
+	}
+}
+
+func makeRestoreRegistrationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RegistrationRequestWithId)
+		reg, err := s.RestoreRegistration(ctx, req.Id)
+		return RestoreRegistrationResponse{Registration: reg, Err: err}, nil
+	}
+}
+
 func makeGetRegistrationEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(RegistrationRequestWithId)
-		reg := s.FindById(req.Id)
+		reg, err := s.FindById(ctx, req.Id)
+		if err != nil {
+			return GetRegistrationResponse{Err: err}, nil
+		}
+		if reg == nil {
+			return GetRegistrationResponse{Err: ErrRegistrationNotFound}, nil
+		}
 		return GetRegistrationResponse{Registration: reg}, nil
 	}
 }
 
 func makeGetAllRegistrationsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		reg := s.AllRegistrations()
-		return GetAllRegistrationsResponse{Registrations: reg}, nil
+		req := request.(GetAllRegistrationsRequest)
+		page, err := s.ListRegistrations(ctx, req.Options)
+		if err != nil {
+			return GetAllRegistrationsResponse{Err: err}, nil
+		}
+
+		return GetAllRegistrationsResponse{
+			Registrations: page.Registrations,
+			NextCursor:    page.NextCursor,
+			Err:           nil,
+		}, nil
 	}
 }
@@ -1,6 +1,7 @@
 package registration
 
 import (
+	"context"
 	"time"
 
 	"github.com/angelcaban/mud/model"
@@ -19,45 +20,111 @@ func NewInstrumentationService(counter metrics.Counter, latency metrics.Histogra
 	return &instrumentationService{counter, latency, s}
 }
 
-func (s *instrumentationService) NewRegistration(username string, password []byte, email string,
+func (s *instrumentationService) NewRegistration(ctx context.Context, username string, password []byte, email string,
 	shortBio string, timezone string) (reg *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.requestCount.With("method", "new registration").Add(1)
 		s.requestLatency.With("method", "new registration").Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	return s.Service.NewRegistration(username, password, email, shortBio, timezone)
+	return s.Service.NewRegistration(ctx, username, password, email, shortBio, timezone)
 }
 
-func (s *instrumentationService) EditRegistration(id uuid.UUID, username string,
-	password []byte, email string, shortBio string, timezone string, validated bool) (reg *model.Registration, err error) {
+func (s *instrumentationService) EditRegistration(ctx context.Context, id uuid.UUID,
+	patch RegistrationPatch) (reg *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.requestCount.With("method", "edit registration").Add(1)
 		s.requestLatency.With("method", "edit registration").Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	return s.Service.EditRegistration(id, username, password, email, shortBio,
-		timezone, validated)
+	return s.Service.EditRegistration(ctx, id, patch)
 }
 
-func (s *instrumentationService) DeleteRegistration(id uuid.UUID) (err error) {
+func (s *instrumentationService) DeleteRegistration(ctx context.Context, id uuid.UUID, reason string) (err error) {
 	defer func(begin time.Time) {
 		s.requestCount.With("method", "delete registration").Add(1)
 		s.requestLatency.With("method", "delete registration").Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	return s.Service.DeleteRegistration(id)
+	return s.Service.DeleteRegistration(ctx, id, reason)
 }
 
-func (s *instrumentationService) FindById(id uuid.UUID) (regs *model.Registration) {
+func (s *instrumentationService) DeactivateRegistration(ctx context.Context, id uuid.UUID, status string,
+	reason string) (err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "deactivate registration").Add(1)
+		s.requestLatency.With("method", "deactivate registration").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.DeactivateRegistration(ctx, id, status, reason)
+}
+
+func (s *instrumentationService) RestoreRegistration(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "restore registration").Add(1)
+		s.requestLatency.With("method", "restore registration").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.RestoreRegistration(ctx, id)
+}
+
+func (s *instrumentationService) PurgeRegistrations(ctx context.Context, deletedBefore time.Time) (int, error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "purge registrations").Add(1)
+		s.requestLatency.With("method", "purge registrations").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.PurgeRegistrations(ctx, deletedBefore)
+}
+
+func (s *instrumentationService) FindById(ctx context.Context, id uuid.UUID) (regs *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.requestCount.With("method", "find registration").Add(1)
 		s.requestLatency.With("method", "find registration").Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	return s.Service.FindById(id)
+	return s.Service.FindById(ctx, id)
+}
+
+func (s *instrumentationService) ListRegistrations(ctx context.Context,
+	opts ListOptions) (page *RegistrationPage, err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "list registrations").Add(1)
+		s.requestLatency.With("method", "list registrations").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.ListRegistrations(ctx, opts)
+}
+
+func (s *instrumentationService) Authenticate(ctx context.Context, username string,
+	password []byte) (reg *model.Registration, err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "authenticate").Add(1)
+		s.requestLatency.With("method", "authenticate").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.Authenticate(ctx, username, password)
+}
+
+func (s *instrumentationService) ConfirmEmail(ctx context.Context, token string) (reg *model.Registration, err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "confirm email").Add(1)
+		s.requestLatency.With("method", "confirm email").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.ConfirmEmail(ctx, token)
+}
+
+func (s *instrumentationService) ResendVerification(ctx context.Context, email string) (err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "resend verification").Add(1)
+		s.requestLatency.With("method", "resend verification").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.ResendVerification(ctx, email)
+}
+
+func (s *instrumentationService) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "request password reset").Add(1)
+		s.requestLatency.With("method", "request password reset").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.RequestPasswordReset(ctx, email)
 }
 
-func (s *instrumentationService) AllRegistrations() (regs []*model.Registration) {
+func (s *instrumentationService) ResetPassword(ctx context.Context, token string, password []byte) (err error) {
 	defer func(begin time.Time) {
-		s.requestCount.With("method", "all registration").Add(1)
-		s.requestLatency.With("method", "all registration").Observe(time.Since(begin).Seconds())
+		s.requestCount.With("method", "reset password").Add(1)
+		s.requestLatency.With("method", "reset password").Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	return s.Service.AllRegistrations()
+	return s.Service.ResetPassword(ctx, token, password)
 }
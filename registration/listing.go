@@ -0,0 +1,159 @@
+package registration
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/angelcaban/mud/model"
+	"github.com/gofrs/uuid"
+)
+
+// Fields registrations can be sorted by
+const (
+	SortByName  = "name"
+	SortByEmail = "email"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ListOptions selects which registrations ListRegistrations returns, in
+// which order and from where to continue.
+type ListOptions struct {
+	// Maximum number of registrations per page, DefaultPageSize if zero
+	Limit int
+
+	// Opaque position returned as NextCursor by the previous page
+	Cursor string
+
+	// Only registrations with this validation state, if set
+	Validated *bool
+
+	// Only registrations in this state, model.StatusActive if empty
+	Status string
+
+	// Only registrations whose email address is at this domain, if set
+	EmailDomain string
+
+	// Only registrations whose username starts with this prefix, if set
+	NamePrefix string
+
+	// Field to sort by, SortByName if empty
+	SortBy string
+
+	// Sort in descending instead of ascending order
+	Descending bool
+}
+
+// RegistrationPage is one page of a registration listing.
+type RegistrationPage struct {
+	Registrations []*model.Registration
+
+	// Cursor for the following page, empty on the last page
+	NextCursor string
+}
+
+// RegistrationQuery is what a RegistrationRepository needs to fetch a page.
+type RegistrationQuery struct {
+	Status      string
+	Validated   *bool
+	EmailDomain string
+	NamePrefix  string
+	SortBy      string
+	Descending  bool
+
+	// Only registrations sorting strictly after this position, if set
+	After *RegistrationCursor
+
+	Limit int
+}
+
+// RegistrationCursor is the position of a registration within a sort order.
+// Registrations sharing the same sort key are ordered by Id.
+type RegistrationCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Key        string    `json:"k"`
+	Id         uuid.UUID `json:"i"`
+}
+
+// SortKey returns the value a registration is sorted by for the given field.
+func SortKey(reg *model.Registration, sortBy string) string {
+	if sortBy == SortByEmail {
+		return reg.Email
+	}
+	return reg.Name
+}
+
+func encodeCursor(cursor RegistrationCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string) (*RegistrationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidArgument.Withf("malformed cursor")
+	}
+
+	cursor := &RegistrationCursor{}
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		return nil, ErrInvalidArgument.Withf("malformed cursor")
+	}
+	return cursor, nil
+}
+
+// queryFromOptions validates and normalizes listing options into a query.
+func queryFromOptions(opts ListOptions) (RegistrationQuery, error) {
+	query := RegistrationQuery{
+		Status:      opts.Status,
+		Validated:   opts.Validated,
+		EmailDomain: strings.TrimPrefix(opts.EmailDomain, "@"),
+		NamePrefix:  opts.NamePrefix,
+		SortBy:      opts.SortBy,
+		Descending:  opts.Descending,
+		Limit:       opts.Limit,
+	}
+
+	switch query.Status {
+	case "":
+		query.Status = model.StatusActive
+	case model.StatusActive, model.StatusDeleted, model.StatusBanned, model.StatusSuspended:
+	default:
+		return query, ErrInvalidArgument.Withf("unknown status %q", query.Status)
+	}
+
+	switch query.SortBy {
+	case "":
+		query.SortBy = SortByName
+	case SortByName, SortByEmail:
+	default:
+		return query, ErrInvalidArgument.Withf("cannot sort by %q", query.SortBy)
+	}
+
+	switch {
+	case query.Limit < 0:
+		return query, ErrInvalidArgument.Withf("limit must not be negative")
+	case query.Limit == 0:
+		query.Limit = DefaultPageSize
+	case query.Limit > MaxPageSize:
+		query.Limit = MaxPageSize
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return query, err
+		}
+		// A cursor only makes sense within the order it was issued for
+		if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+			return query, ErrInvalidArgument.Withf("cursor was issued for another sort order")
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
@@ -1,6 +1,8 @@
 package registration
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/angelcaban/mud/model"
@@ -17,7 +19,7 @@ func NewLoggingService(logger log.Logger, s Service) Service {
 	return &loggingService{logger, s}
 }
 
-func (s *loggingService) NewRegistration(username string, password []byte, email string,
+func (s *loggingService) NewRegistration(ctx context.Context, username string, password []byte, email string,
 	shortBio string, timezone string) (reg *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
@@ -29,55 +31,157 @@ func (s *loggingService) NewRegistration(username string, password []byte, email
 			"elapsed", time.Since(begin),
 			"err", err)
 	}(time.Now())
-	return s.Service.NewRegistration(username, password, email, shortBio, timezone)
+	return s.Service.NewRegistration(ctx, username, password, email, shortBio, timezone)
 }
 
-func (s *loggingService) EditRegistration(id uuid.UUID, username string,
-	password []byte, email string, shortBio string, timezone string, validated bool) (reg *model.Registration, err error) {
+func (s *loggingService) EditRegistration(ctx context.Context, id uuid.UUID,
+	patch RegistrationPatch) (reg *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
 			"method", "edit registration",
 			"id", id,
-			"username", username,
-			"email", email,
-			"shortBio", shortBio,
-			"timezone", timezone,
-			"validated", validated,
+			"fields", strings.Join(patch.Fields(), ","),
 			"elapsed", time.Since(begin),
 			"err", err)
 	}(time.Now())
-	return s.Service.EditRegistration(id, username, password, email, shortBio,
-		timezone, validated)
+	return s.Service.EditRegistration(ctx, id, patch)
 }
 
-func (s *loggingService) DeleteRegistration(id uuid.UUID) (err error) {
+func (s *loggingService) DeleteRegistration(ctx context.Context, id uuid.UUID, reason string) (err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
 			"method", "delete registration",
 			"id", id,
+			"reason", reason,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.DeleteRegistration(ctx, id, reason)
+}
+
+func (s *loggingService) DeactivateRegistration(ctx context.Context, id uuid.UUID, status string,
+	reason string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "deactivate registration",
+			"id", id,
+			"status", status,
+			"reason", reason,
 			"elapsed", time.Since(begin),
 			"err", err)
 	}(time.Now())
-	return s.Service.DeleteRegistration(id)
+	return s.Service.DeactivateRegistration(ctx, id, status, reason)
 }
 
-func (s *loggingService) FindById(id uuid.UUID) (regs *model.Registration) {
+func (s *loggingService) RestoreRegistration(ctx context.Context, id uuid.UUID) (reg *model.Registration, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "restore registration",
+			"id", id,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.RestoreRegistration(ctx, id)
+}
+
+func (s *loggingService) PurgeRegistrations(ctx context.Context, deletedBefore time.Time) (purged int, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "purge registrations",
+			"deletedBefore", deletedBefore,
+			"purged", purged,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.PurgeRegistrations(ctx, deletedBefore)
+}
+
+func (s *loggingService) FindById(ctx context.Context, id uuid.UUID) (regs *model.Registration, err error) {
 	defer func(begin time.Time) {
 		s.logger.Log(
 			"method", "find registration",
 			"id", id,
 			"isFound", regs != nil,
-			"elapsed", time.Since(begin))
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.FindById(ctx, id)
+}
+
+func (s *loggingService) ListRegistrations(ctx context.Context,
+	opts ListOptions) (page *RegistrationPage, err error) {
+	defer func(begin time.Time) {
+		count := 0
+		if page != nil {
+			count = len(page.Registrations)
+		}
+		s.logger.Log(
+			"method", "list registrations",
+			"limit", opts.Limit,
+			"sortBy", opts.SortBy,
+			"descending", opts.Descending,
+			"count", count,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.ListRegistrations(ctx, opts)
+}
+
+func (s *loggingService) Authenticate(ctx context.Context, username string,
+	password []byte) (reg *model.Registration, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "authenticate",
+			"username", username,
+			"elapsed", time.Since(begin),
+			"err", err)
 	}(time.Now())
-	return s.Service.FindById(id)
+	return s.Service.Authenticate(ctx, username, password)
 }
 
-func (s *loggingService) AllRegistrations() (regs []*model.Registration) {
+func (s *loggingService) ConfirmEmail(ctx context.Context, token string) (reg *model.Registration, err error) {
 	defer func(begin time.Time) {
+		id := uuid.Nil
+		if reg != nil {
+			id = reg.Id
+		}
 		s.logger.Log(
-			"method", "all registration",
-			"count", len(regs),
-			"elapsed", time.Since(begin))
+			"method", "confirm email",
+			"id", id,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.ConfirmEmail(ctx, token)
+}
+
+func (s *loggingService) ResendVerification(ctx context.Context, email string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "resend verification",
+			"email", email,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.ResendVerification(ctx, email)
+}
+
+func (s *loggingService) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "request password reset",
+			"email", email,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.RequestPasswordReset(ctx, email)
+}
+
+func (s *loggingService) ResetPassword(ctx context.Context, token string, password []byte) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "reset password",
+			"elapsed", time.Since(begin),
+			"err", err)
 	}(time.Now())
-	return s.Service.AllRegistrations()
+	return s.Service.ResetPassword(ctx, token, password)
 }
@@ -0,0 +1,230 @@
+package registration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("Password Mismatch")
+var ErrUnknownPasswordHash = errors.New("Unknown Password Hash Algorithm")
+
+// PasswordHasher turns plain-text passwords into encoded hashes. Every encoded
+// hash carries its algorithm and cost parameters so it can still be verified
+// after the configured policy changes.
+type PasswordHasher interface {
+	// Hash a plain-text password into its encoded form
+	Hash(password []byte) ([]byte, error)
+
+	// Compare an encoded hash with a plain-text password
+	Compare(hash []byte, password []byte) error
+
+	// Report whether an encoded hash was produced by this algorithm
+	Recognizes(hash []byte) bool
+
+	// Report whether an encoded hash is weaker than the current settings
+	NeedsRehash(hash []byte) bool
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher creates a PasswordHasher using bcrypt with the given cost.
+func NewBcryptHasher(cost int) PasswordHasher {
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptHasher{cost: cost}
+}
+
+func (h *bcryptHasher) Hash(password []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(password, h.cost)
+}
+
+func (h *bcryptHasher) Compare(hash []byte, password []byte) error {
+	err := bcrypt.CompareHashAndPassword(hash, password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *bcryptHasher) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) ||
+		bytes.HasPrefix(hash, []byte("$2b$")) ||
+		bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h *bcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return true
+	}
+	return cost < h.cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idParams are the cost parameters for the argon2id algorithm.
+type Argon2idParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// DefaultArgon2idParams follows the RFC 9106 second recommended option.
+var DefaultArgon2idParams = Argon2idParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher creates a PasswordHasher using argon2id. Hashes are
+// encoded in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func NewArgon2idHasher(params Argon2idParams) PasswordHasher {
+	return &argon2idHasher{params: params}
+}
+
+func (h *argon2idHasher) Hash(password []byte) ([]byte, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, h.params.Time, h.params.Memory,
+		h.params.Threads, h.params.KeyLen)
+
+	return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix,
+		argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+func (h *argon2idHasher) Compare(hash []byte, password []byte) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey(password, salt, params.Time, params.Memory,
+		params.Threads, params.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *argon2idHasher) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func (h *argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time < h.params.Time ||
+		params.Memory < h.params.Memory ||
+		params.Threads < h.params.Threads ||
+		params.KeyLen < h.params.KeyLen ||
+		params.SaltLen < h.params.SaltLen
+}
+
+func decodeArgon2id(hash []byte) (params Argon2idParams, salt []byte, key []byte, err error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory,
+		&params.Time, &params.Threads); err != nil {
+		return params, nil, nil, err
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, err
+	}
+
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+type passwordPolicy struct {
+	preferred PasswordHasher
+	accepted  []PasswordHasher
+}
+
+// NewPasswordPolicy creates a PasswordHasher which hashes new passwords with
+// the preferred algorithm while still verifying hashes produced by any of the
+// accepted ones. Hashes not produced by the preferred algorithm, or produced
+// with weaker settings, are reported as needing a rehash.
+func NewPasswordPolicy(preferred PasswordHasher, accepted ...PasswordHasher) PasswordHasher {
+	return &passwordPolicy{
+		preferred: preferred,
+		accepted:  accepted,
+	}
+}
+
+func (p *passwordPolicy) Hash(password []byte) ([]byte, error) {
+	return p.preferred.Hash(password)
+}
+
+func (p *passwordPolicy) Compare(hash []byte, password []byte) error {
+	hasher := p.hasherFor(hash)
+	if hasher == nil {
+		return ErrUnknownPasswordHash
+	}
+	return hasher.Compare(hash, password)
+}
+
+func (p *passwordPolicy) Recognizes(hash []byte) bool {
+	return p.hasherFor(hash) != nil
+}
+
+func (p *passwordPolicy) NeedsRehash(hash []byte) bool {
+	if !p.preferred.Recognizes(hash) {
+		return true
+	}
+	return p.preferred.NeedsRehash(hash)
+}
+
+func (p *passwordPolicy) hasherFor(hash []byte) PasswordHasher {
+	if p.preferred.Recognizes(hash) {
+		return p.preferred
+	}
+	for _, hasher := range p.accepted {
+		if hasher.Recognizes(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package registration
+
+// RegistrationPatch describes a change to an existing account. Nil fields are
+// left untouched; an empty ShortBio or TimeZone resets it to its default.
+type RegistrationPatch struct {
+	Username *string
+	Password []byte
+	Email    *string
+	ShortBio *string
+	TimeZone *string
+
+	// Validated may only be cleared; use ConfirmEmail to set it
+	Validated *bool
+
+	// Only administrators may change roles
+	Role *string
+
+	// Only apply the patch to this version of the registration, if non-zero
+	IfVersion int64
+}
+
+// Fields lists the names of the fields the patch changes.
+func (p RegistrationPatch) Fields() []string {
+	fields := []string{}
+	if p.Username != nil {
+		fields = append(fields, "username")
+	}
+	if p.Password != nil {
+		fields = append(fields, "password")
+	}
+	if p.Email != nil {
+		fields = append(fields, "email")
+	}
+	if p.ShortBio != nil {
+		fields = append(fields, "shortbio")
+	}
+	if p.TimeZone != nil {
+		fields = append(fields, "timezone")
+	}
+	if p.Validated != nil {
+		fields = append(fields, "validated")
+	}
+	if p.Role != nil {
+		fields = append(fields, "role")
+	}
+	return fields
+}
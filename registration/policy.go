@@ -0,0 +1,35 @@
+package registration
+
+import (
+	"github.com/angelcaban/mud/auth"
+	"github.com/gofrs/uuid"
+)
+
+// selfOrAdmin lets account holders act on their own account and
+// administrators on any. Only administrators may change roles.
+func selfOrAdmin(p *auth.Principal, request interface{}) error {
+	if p == nil {
+		return auth.ErrUnauthenticated
+	}
+	if p.IsAdmin() {
+		return nil
+	}
+
+	var id uuid.UUID
+	switch req := request.(type) {
+	case PatchRegistrationRequest:
+		if req.Patch.Role != nil {
+			return auth.ErrForbidden
+		}
+		id = req.Id
+	case RegistrationRequestWithId:
+		id = req.Id
+	case DeleteRegistrationRequest:
+		id = req.Id
+	}
+
+	if id != p.AccountId {
+		return auth.ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package registration
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+const (
+	DefaultDeletedRetention = 30 * 24 * time.Hour
+	DefaultPurgeInterval    = time.Hour
+)
+
+// RunPurger removes accounts deleted longer than retention ago, once at
+// start and then every interval, until ctx is done.
+func RunPurger(ctx context.Context, s Service, retention time.Duration, interval time.Duration,
+	logger kitlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeRegistrations(ctx, time.Now().UTC().Add(-retention)); err != nil {
+			logger.Log("msg", "purging deleted registrations failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
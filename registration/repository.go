@@ -1,101 +1,387 @@
 package registration
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	st "github.com/Masterminds/structable"
 	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/storage"
 	"github.com/gofrs/uuid"
 )
 
 const (
-	REGISTRATION_TABLE = "registrations"
-	CONNECTION_STRING  = "/muddb"
+	REGISTRATION_TABLE  = "registrations"
+	ACCOUNT_TOKEN_TABLE = "account_tokens"
 )
 
 type RegistrationRepository interface {
-	// Save a registration into the database
-	Store(registration *model.Registration) (*model.Registration, error)
+	// Insert a new registration into the database, failing with
+	// ErrRegistrationExists if its id, username or email is taken
+	Create(ctx context.Context, registration *model.Registration) (*model.Registration, error)
 
-	// Find a registration from the database given an ID
-	Find(id uuid.UUID) *model.Registration
+	// Update an existing registration as long as the stored version still
+	// matches its Version, failing with ErrRegistrationNotFound or
+	// ErrVersionConflict otherwise
+	Update(ctx context.Context, registration *model.Registration) (*model.Registration, error)
 
-	// Delete a registration from the database given an ID
-	Delete(id uuid.UUID) error
+	// Update a registration whatever its stored version, or insert it if it
+	// does not exist, in a single statement. It never fails with
+	// ErrVersionConflict, only with ErrRegistrationExists if another
+	// registration has its username or email
+	Upsert(ctx context.Context, registration *model.Registration) (*model.Registration, error)
 
-	// Get a list of all registrations
-	FindAll() []*model.Registration
+	// Find an active registration from the database given an ID. The Find
+	// methods return nil without an error if nothing matches
+	Find(ctx context.Context, id uuid.UUID) (*model.Registration, error)
+
+	// Find a registration from the database given an ID, whatever its status
+	FindAny(ctx context.Context, id uuid.UUID) (*model.Registration, error)
+
+	// Find an active registration from the database given a username, ignoring case
+	FindByName(ctx context.Context, name string) (*model.Registration, error)
+
+	// Find an active registration from the database given an email address, ignoring case
+	FindByEmail(ctx context.Context, email string) (*model.Registration, error)
+
+	// Get a filtered and sorted page of registrations
+	List(ctx context.Context, query RegistrationQuery) ([]*model.Registration, error)
+
+	// Remove registrations deleted before the given time, returning their ids
+	Purge(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error)
+
+	// Check that the database is reachable
+	Ping(ctx context.Context) error
 }
 
 type repository struct {
 	Db         sq.DBProxyBeginner
 	DriverName string
+	Timeout    time.Duration
 }
 
-func NewRegistrationRepository(db *sql.DB, driverName string) (RegistrationRepository, error) {
-	conn, err := sql.Open(driverName, CONNECTION_STRING)
-	if err != nil {
-		return nil, err
-	}
+func NewRegistrationRepository(db *sql.DB, driverName string,
+	queryTimeout time.Duration) (RegistrationRepository, error) {
 	return &repository{
-		Db:         sq.NewStmtCacheProxy(conn),
+		Db:         storage.NewStmtCacheProxy(db),
 		DriverName: driverName,
+		Timeout:    queryTimeout,
 	}, nil
 }
 
-func (repo *repository) Store(registration *model.Registration) (*model.Registration, error) {
-	recorder := st.New(repo.Db, repo.DriverName).Bind(REGISTRATION_TABLE, registration)
-	err := recorder.Insert()
+func (repo *repository) Create(ctx context.Context, registration *model.Registration) (*model.Registration, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	previous := registration.Version
+	registration.Version = 1
+
+	recorder := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, registration)
+	if err := storage.Insert(ctx, recorder); err != nil {
+		registration.Version = previous
+		return nil, translateError(err)
+	}
+	return registration, nil
+}
+
+func (repo *repository) Update(ctx context.Context, registration *model.Registration) (*model.Registration, error) {
+	updated, err := repo.update(ctx, registration)
 	if err != nil {
 		return nil, err
 	}
-
-	recorder.Load()
+	if !updated {
+		stored, err := repo.FindAny(ctx, registration.Id)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return nil, ErrRegistrationNotFound.Withf("for id %v", registration.Id)
+		}
+		return nil, ErrVersionConflict
+	}
 	return registration, nil
 }
 
-func (repo *repository) Find(id uuid.UUID) *model.Registration {
-	type_ := &model.Registration{}
-	db_conn := st.New(repo.Db, repo.DriverName).Bind(REGISTRATION_TABLE, type_)
-	rec, err := st.ListWhere(db_conn,
-		func(object st.Describer, sql sq.SelectBuilder) (sq.SelectBuilder, error) {
-			return sql.Limit(1).Where(sq.Eq{"id": id}), nil
-		})
+func (repo *repository) Upsert(ctx context.Context, registration *model.Registration) (*model.Registration, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	previous := registration.Version
+	registration.Version = 1
+
+	recorder := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, registration)
+	err := storage.Upsert(ctx, recorder, repo.DriverName, "version")
+	registration.Version = previous
 	if err != nil {
-		return nil
+		return nil, translateError(err)
 	}
 
-	return rec[0].Interface().(*model.Registration)
+	// The stored version is only known by reading it back, which also tells
+	// whether MySQL skipped the row over another account's username or email
+	stored, err := repo.FindAny(ctx, registration.Id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || !strings.EqualFold(stored.Name, registration.Name) ||
+		!strings.EqualFold(stored.Email, registration.Email) {
+		return nil, ErrRegistrationExists
+	}
+	registration.Version = stored.Version
+	return registration, nil
 }
 
-func (repo *repository) Delete(id uuid.UUID) error {
-	reg := &model.Registration{Id: id}
-	rec := st.New(repo.Db, repo.DriverName).Bind(REGISTRATION_TABLE, reg)
-	return rec.Delete()
+// update writes a registration over the stored row if the stored version
+// still matches, bumping its Version. It reports whether a row was written.
+func (repo *repository) update(ctx context.Context, registration *model.Registration) (bool, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	expected := registration.Version
+	registration.Version++
+
+	recorder := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, registration)
+	updated, err := storage.Update(ctx, recorder, sq.Eq{"version": expected})
+	if err != nil || updated == 0 {
+		registration.Version = expected
+		return false, translateError(err)
+	}
+	return true, nil
+}
+
+func (repo *repository) Ping(ctx context.Context) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	return storage.Ping(ctx, storage.Runner(ctx, repo.Db))
+}
+
+func (repo *repository) Find(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	return repo.findWhere(ctx, sq.Eq{"id": id, "status": model.StatusActive})
+}
+
+func (repo *repository) FindAny(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	return repo.findWhere(ctx, sq.Eq{"id": id})
+}
+
+func (repo *repository) FindByName(ctx context.Context, name string) (*model.Registration, error) {
+	return repo.findWhere(ctx, sq.Eq{"name": name, "status": model.StatusActive})
+}
+
+func (repo *repository) FindByEmail(ctx context.Context, email string) (*model.Registration, error) {
+	return repo.findWhere(ctx, sq.Eq{"email": email, "status": model.StatusActive})
 }
 
-func (repo *repository) FindAll() []*model.Registration {
+func (repo *repository) findWhere(ctx context.Context, pred interface{}) (*model.Registration, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
 	reg := &model.Registration{}
-	rec := st.New(repo.Db, repo.DriverName).Bind(REGISTRATION_TABLE, reg)
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, reg)
+	if err := storage.LoadWhere(ctx, rec, pred); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, storage.Error(err)
+	}
+
+	return reg, nil
+}
+
+func (repo *repository) List(ctx context.Context, query RegistrationQuery) ([]*model.Registration, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	// Never let the sort field reach the statement unchecked
+	column := SortByName
+	if query.SortBy == SortByEmail {
+		column = SortByEmail
+	}
+	order, after := "ASC", ">"
+	if query.Descending {
+		order, after = "DESC", "<"
+	}
+
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, &model.Registration{})
+	sel := rec.Builder().
+		Select(rec.Columns(true)...).
+		From(REGISTRATION_TABLE).
+		Where(sq.Eq{"status": query.Status}).
+		OrderBy(column+" "+order, "id "+order).
+		Limit(uint64(query.Limit))
+
+	if query.Validated != nil {
+		sel = sel.Where(sq.Eq{"validated": *query.Validated})
+	}
+	if query.EmailDomain != "" {
+		sel = sel.Where(storage.Like("email", "%@"+storage.EscapeLike(query.EmailDomain)))
+	}
+	if query.NamePrefix != "" {
+		sel = sel.Where(storage.Like("name", storage.EscapeLike(query.NamePrefix)+"%"))
+	}
+	if query.After != nil {
+		sel = sel.Where(sq.Or{
+			sq.Expr(column+" "+after+" ?", query.After.Key),
+			sq.And{
+				sq.Eq{column: query.After.Key},
+				sq.Expr("id "+after+" ?", query.After.Id),
+			},
+		})
+	}
 
-	allRegs := make([]*model.Registration, 1)
+	rows, err := sel.QueryContext(ctx)
+	if err != nil {
+		return nil, storage.Error(err)
+	}
+	defer rows.Close()
 
-	var offset uint64 = 0
-	var maxPageSize uint64 = 1000
-	for {
-		items, err := st.List(rec, maxPageSize, offset)
-		if err == nil || len(items) > 0 {
-			break
+	regs := []*model.Registration{}
+	for rows.Next() {
+		reg := &model.Registration{}
+		item := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(REGISTRATION_TABLE, reg)
+		if err := rows.Scan(item.FieldReferences(true)...); err != nil {
+			return nil, storage.Error(err)
 		}
+		regs = append(regs, reg)
+	}
 
-		slice_ := make([]*model.Registration, len(items))
-		for i, item := range items {
-			slice_[i] = item.Interface().(*model.Registration)
+	return regs, storage.Error(rows.Err())
+}
+
+func (repo *repository) Purge(ctx context.Context, deletedBefore time.Time) ([]uuid.UUID, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	// SQLite compares times as text, so they must share the UTC offset the
+	// status changes are stored with
+	runner := storage.Runner(ctx, repo.Db)
+	expired := sq.And{
+		sq.Eq{"status": model.StatusDeleted},
+		sq.Lt{"status_changed_at": deletedBefore.UTC()},
+	}
+
+	rows, err := sq.StatementBuilder.RunWith(runner).
+		Select("id").
+		From(REGISTRATION_TABLE).
+		Where(expired).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, storage.Error(err)
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, storage.Error(err)
 		}
-		allRegs = append(allRegs, slice_...)
-		offset += uint64(len(items))
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storage.Error(err)
 	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	_, err = sq.StatementBuilder.RunWith(runner).
+		Delete(REGISTRATION_TABLE).
+		Where(sq.Eq{"id": ids}).
+		Where(expired).
+		ExecContext(ctx)
+	if err != nil {
+		return nil, storage.Error(err)
+	}
+	return ids, nil
+}
+
+// translateError maps driver specific errors onto the package's errors. Name
+// and email uniqueness is enforced by the schema with case-insensitive keys.
+func translateError(err error) error {
+	if storage.IsDuplicateKey(err) {
+		return ErrRegistrationExists.Wrap(err)
+	}
+	return err
+}
+
+type TokenRepository interface {
+	// Save a new account token into the database
+	Store(ctx context.Context, token *model.AccountToken) (*model.AccountToken, error)
+
+	// Find a token for the given purpose from the database given its hash, or
+	// nil if there is none
+	FindByTokenHash(ctx context.Context, purpose string, tokenHash []byte) (*model.AccountToken, error)
+
+	// Delete a token from the database given an ID
+	Delete(ctx context.Context, id uuid.UUID) error
+
+	// Delete every token an account holds for the given purpose
+	DeleteByAccount(ctx context.Context, accountId uuid.UUID, purpose string) error
+}
+
+type tokenRepository struct {
+	Db         sq.DBProxyBeginner
+	DriverName string
+	Timeout    time.Duration
+}
+
+func NewTokenRepository(db *sql.DB, driverName string,
+	queryTimeout time.Duration) (TokenRepository, error) {
+	return &tokenRepository{
+		Db:         storage.NewStmtCacheProxy(db),
+		DriverName: driverName,
+		Timeout:    queryTimeout,
+	}, nil
+}
+
+func (repo *tokenRepository) Store(ctx context.Context, token *model.AccountToken) (*model.AccountToken, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(ACCOUNT_TOKEN_TABLE, token)
+	if err := storage.Insert(ctx, rec); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (repo *tokenRepository) FindByTokenHash(ctx context.Context, purpose string,
+	tokenHash []byte) (*model.AccountToken, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	token := &model.AccountToken{}
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(ACCOUNT_TOKEN_TABLE, token)
+	if err := storage.LoadWhere(ctx, rec,
+		sq.Eq{"purpose": purpose, "token_hash": tokenHash}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, storage.Error(err)
+	}
+
+	return token, nil
+}
+
+func (repo *tokenRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	token := &model.AccountToken{Id: id}
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(ACCOUNT_TOKEN_TABLE, token)
+	return storage.Delete(ctx, rec)
+}
+
+func (repo *tokenRepository) DeleteByAccount(ctx context.Context, accountId uuid.UUID,
+	purpose string) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
 
-	return allRegs
+	return storage.DeleteWhere(ctx, storage.Runner(ctx, repo.Db), ACCOUNT_TOKEN_TABLE,
+		sq.Eq{"account_id": accountId, "purpose": purpose})
 }
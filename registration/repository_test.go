@@ -0,0 +1,309 @@
+package registration_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelcaban/mud/inmem"
+	"github.com/angelcaban/mud/migrate"
+	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/registration"
+	"github.com/angelcaban/mud/storage"
+	"github.com/gofrs/uuid"
+)
+
+// backends opens a fresh registration repository of every kind the server
+// supports without an external database.
+var backends = map[string]func(t *testing.T) registration.RegistrationRepository{
+	storage.DriverSQLite: newSQLiteRepository,
+	storage.DriverMemory: func(*testing.T) registration.RegistrationRepository {
+		return inmem.NewRegistrationRepository()
+	},
+}
+
+func newSQLiteRepository(t *testing.T) registration.RegistrationRepository {
+	dir, err := ioutil.TempDir("", "mud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dsn := "file:" + filepath.Join(dir, "mud.db") + "?_foreign_keys=1&_busy_timeout=5000"
+	db, err := storage.Open(storage.DriverSQLite, dsn, storage.Pool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrate.New(db, storage.DriverSQLite).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := registration.NewRegistrationRepository(db, storage.DriverSQLite, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// forEachBackend runs test against every backend in its own subtest.
+func forEachBackend(t *testing.T, test func(t *testing.T, repo registration.RegistrationRepository)) {
+	for name, open := range backends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
+
+func newRegistration(t *testing.T, name string) *model.Registration {
+	id, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.Registration{
+		Id:       id,
+		Name:     name,
+		Email:    name + "@example.com",
+		Password: []byte("hash"),
+		TimeZone: "UTC",
+		Role:     model.RolePlayer,
+		Status:   model.StatusActive,
+	}
+}
+
+func mustCreate(t *testing.T, repo registration.RegistrationRepository, name string) *model.Registration {
+	reg, err := repo.Create(context.Background(), newRegistration(t, name))
+	if err != nil {
+		t.Fatalf("Create(%q): %v", name, err)
+	}
+	return reg
+}
+
+// find looks a registration up with one of the repository's Find methods,
+// failing the test on an error.
+func find(t *testing.T, finder func(context.Context, uuid.UUID) (*model.Registration, error),
+	id uuid.UUID) *model.Registration {
+	t.Helper()
+	reg, err := finder(context.Background(), id)
+	if err != nil {
+		t.Fatalf("find %v: %v", id, err)
+	}
+	return reg
+}
+
+func expectError(t *testing.T, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo registration.RegistrationRepository) {
+		ctx := context.Background()
+
+		reg := mustCreate(t, repo, "alice")
+		if reg.Version != 1 {
+			t.Errorf("created version %d, want 1", reg.Version)
+		}
+
+		found := find(t, repo.Find, reg.Id)
+		if found == nil {
+			t.Fatal("created registration not found")
+		}
+		if found.Name != "alice" || found.Version != 1 {
+			t.Errorf("found %q version %d, want alice version 1", found.Name, found.Version)
+		}
+		if found.StatusChangedAt.Valid {
+			t.Errorf("status_changed_at %v, want unset", found.StatusChangedAt.Time)
+		}
+
+		sameId := newRegistration(t, "bob")
+		sameId.Id = reg.Id
+		_, err := repo.Create(ctx, sameId)
+		expectError(t, err, registration.ErrRegistrationExists)
+
+		sameName := newRegistration(t, "ALICE")
+		_, err = repo.Create(ctx, sameName)
+		expectError(t, err, registration.ErrRegistrationExists)
+
+		sameEmail := newRegistration(t, "carol")
+		sameEmail.Email = "Alice@Example.com"
+		_, err = repo.Create(ctx, sameEmail)
+		expectError(t, err, registration.ErrRegistrationExists)
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo registration.RegistrationRepository) {
+		ctx := context.Background()
+
+		reg := mustCreate(t, repo, "alice")
+		reg.ShortBio = "Adventurer"
+		updated, err := repo.Update(ctx, reg)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if updated.Version != 2 {
+			t.Errorf("updated version %d, want 2", updated.Version)
+		}
+		if found := find(t, repo.Find, reg.Id); found == nil || found.ShortBio != "Adventurer" || found.Version != 2 {
+			t.Errorf("found %+v, want the update stored at version 2", found)
+		}
+
+		missing := newRegistration(t, "nobody")
+		_, err = repo.Update(ctx, missing)
+		expectError(t, err, registration.ErrRegistrationNotFound)
+
+		stale := *reg
+		stale.Version = 1
+		stale.ShortBio = "Stale"
+		_, err = repo.Update(ctx, &stale)
+		expectError(t, err, registration.ErrVersionConflict)
+		if stale.Version != 1 {
+			t.Errorf("failed update left version %d, want 1", stale.Version)
+		}
+
+		other := mustCreate(t, repo, "bob")
+		other.Name = "Alice"
+		_, err = repo.Update(ctx, other)
+		expectError(t, err, registration.ErrRegistrationExists)
+
+		other = find(t, repo.Find, other.Id)
+		other.Email = "ALICE@example.com"
+		_, err = repo.Update(ctx, other)
+		expectError(t, err, registration.ErrRegistrationExists)
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo registration.RegistrationRepository) {
+		ctx := context.Background()
+
+		reg, err := repo.Upsert(ctx, newRegistration(t, "alice"))
+		if err != nil {
+			t.Fatalf("Upsert of a new registration: %v", err)
+		}
+		if reg.Version != 1 {
+			t.Errorf("inserted version %d, want 1", reg.Version)
+		}
+
+		// Upserts overwrite whatever version is stored
+		stale := *reg
+		stale.Version = 0
+		stale.ShortBio = "Adventurer"
+		updated, err := repo.Upsert(ctx, &stale)
+		if err != nil {
+			t.Fatalf("Upsert of a stale registration: %v", err)
+		}
+		if updated.Version != 2 {
+			t.Errorf("upserted version %d, want 2", updated.Version)
+		}
+		if found := find(t, repo.Find, reg.Id); found == nil || found.ShortBio != "Adventurer" || found.Version != 2 {
+			t.Errorf("found %+v, want the upsert stored at version 2", found)
+		}
+
+		sameName := newRegistration(t, "Alice")
+		_, err = repo.Upsert(ctx, sameName)
+		expectError(t, err, registration.ErrRegistrationExists)
+		if find(t, repo.FindAny, sameName.Id) != nil {
+			t.Error("registration with a taken username was stored")
+		}
+
+		other := mustCreate(t, repo, "bob")
+		other.Email = "alice@EXAMPLE.com"
+		_, err = repo.Upsert(ctx, other)
+		expectError(t, err, registration.ErrRegistrationExists)
+		if found := find(t, repo.FindAny, other.Id); found == nil || found.Email != "bob@example.com" {
+			t.Errorf("found %+v, want bob's email unchanged", found)
+		}
+	})
+}
+
+func TestUpsertConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo registration.RegistrationRepository) {
+		ctx := context.Background()
+		reg := newRegistration(t, "alice")
+
+		const writers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c := *reg
+				if _, err := repo.Upsert(ctx, &c); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("concurrent Upsert: %v", err)
+		}
+		if found := find(t, repo.Find, reg.Id); found == nil || found.Version != writers {
+			t.Errorf("found %+v, want version %d", found, writers)
+		}
+	})
+}
+
+func TestFindFailsWithoutDatabase(t *testing.T) {
+	repo := newSQLiteRepository(t)
+	reg := mustCreate(t, repo, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	found, err := repo.Find(ctx, reg.Id)
+	if err == nil {
+		t.Fatalf("found %+v with a cancelled context, want an error", found)
+	}
+
+	missing, err := repo.Find(context.Background(), uuid.Must(uuid.NewV4()))
+	if missing != nil || err != nil {
+		t.Errorf("got %+v, %v for a missing registration, want nil, nil", missing, err)
+	}
+}
+
+func TestPurgeWithZonedCutoff(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo registration.RegistrationRepository) {
+		ctx := context.Background()
+		deletedAt := time.Now().UTC().Add(-time.Hour)
+
+		reg := newRegistration(t, "alice")
+		reg.Status = model.StatusDeleted
+		reg.StatusChangedAt = sql.NullTime{Time: deletedAt, Valid: true}
+		if _, err := repo.Create(ctx, reg); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		east := time.FixedZone("east", 5*60*60)
+		west := time.FixedZone("west", -5*60*60)
+
+		purged, err := repo.Purge(ctx, deletedAt.Add(-time.Minute).In(east))
+		if err != nil {
+			t.Fatalf("Purge: %v", err)
+		}
+		if len(purged) != 0 {
+			t.Errorf("purged %v with a cutoff before the deletion", purged)
+		}
+
+		purged, err = repo.Purge(ctx, deletedAt.Add(time.Minute).In(west))
+		if err != nil {
+			t.Fatalf("Purge: %v", err)
+		}
+		if len(purged) != 1 || purged[0] != reg.Id {
+			t.Errorf("purged %v with a cutoff after the deletion, want [%v]", purged, reg.Id)
+		}
+	})
+}
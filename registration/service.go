@@ -1,54 +1,153 @@
 package registration
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/audit"
 	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/notify"
+	"github.com/angelcaban/mud/storage"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/gofrs/uuid"
 )
 
-var ErrInvalidArgument = errors.New("Invalid Argument")
-var ErrRegistrationExists = errors.New("Registration Already Exists")
-var ErrRegistrationNotFound = errors.New("Registration Not Found")
+var ErrInvalidArgument = apperr.New(apperr.CodeInvalidArgument,
+	http.StatusBadRequest, "Invalid Argument")
+var ErrValidationFailed = apperr.New(apperr.CodeValidationFailed,
+	http.StatusUnprocessableEntity, "One Or More Fields Are Invalid")
+var ErrRegistrationExists = apperr.New(apperr.CodeRegistrationExists,
+	http.StatusConflict, "Registration Already Exists")
+var ErrRegistrationNotFound = apperr.New(apperr.CodeRegistrationNotFound,
+	http.StatusNotFound, "Registration Not Found")
+var ErrPreconditionFailed = apperr.New(apperr.CodePreconditionFailed,
+	http.StatusPreconditionFailed, "Registration Version Mismatch")
+var ErrVersionConflict = apperr.New(apperr.CodeVersionConflict,
+	http.StatusConflict, "Registration Changed Concurrently")
+var ErrInvalidCredentials = apperr.New(apperr.CodeInvalidCredentials,
+	http.StatusUnauthorized, "Invalid Credentials")
+var ErrInvalidToken = apperr.New(apperr.CodeInvalidToken,
+	http.StatusBadRequest, "Invalid Or Expired Token")
 
 type Service interface {
 	// Register a new account to the system
-	NewRegistration(username string, password []byte, email string,
+	NewRegistration(ctx context.Context, username string, password []byte, email string,
 		shortBio string, timezone string) (*model.Registration, error)
 
 	// Edit the information for an existing account in the system
-	EditRegistration(id uuid.UUID, username string, password []byte, email string,
-		shortBio string, timezone string, validated bool) (*model.Registration, error)
+	EditRegistration(ctx context.Context, id uuid.UUID,
+		patch RegistrationPatch) (*model.Registration, error)
 
-	// Remove an existing account in the system
-	DeleteRegistration(id uuid.UUID) error
+	// Mark an account's email as verified given the token mailed to it
+	ConfirmEmail(ctx context.Context, token string) (*model.Registration, error)
 
-	FindById(id uuid.UUID) *model.Registration
+	// Mail a fresh verification token to an unverified account
+	ResendVerification(ctx context.Context, email string) error
 
-	AllRegistrations() []*model.Registration
+	// Mark an account deleted, hiding it until it is restored or purged
+	DeleteRegistration(ctx context.Context, id uuid.UUID, reason string) error
+
+	// Mark an account deleted, banned or suspended, ending its sessions
+	DeactivateRegistration(ctx context.Context, id uuid.UUID, status string, reason string) error
+
+	// Reactivate a deleted, banned or suspended account
+	RestoreRegistration(ctx context.Context, id uuid.UUID) (*model.Registration, error)
+
+	// Remove accounts deleted before the given time for good, returning how many
+	PurgeRegistrations(ctx context.Context, deletedBefore time.Time) (int, error)
+
+	// Find an active account, or nil if there is none
+	FindById(ctx context.Context, id uuid.UUID) (*model.Registration, error)
+
+	// List one page of accounts matching the given filters
+	ListRegistrations(ctx context.Context, opts ListOptions) (*RegistrationPage, error)
+
+	// Verify an account's password, upgrading its hash if the policy changed
+	Authenticate(ctx context.Context, username string, password []byte) (*model.Registration, error)
+
+	// Mail a password reset token to the account owning an email address
+	RequestPasswordReset(ctx context.Context, email string) error
+
+	// Replace an account's password given a reset token, ending its sessions
+	ResetPassword(ctx context.Context, token string, password []byte) error
+}
+
+// SessionRevoker ends every login session of an account. It is satisfied by
+// session.SessionRepository.
+type SessionRevoker interface {
+	DeleteByAccount(ctx context.Context, accountId uuid.UUID) error
 }
 
 type service struct {
-	regRepository RegistrationRepository
+	regRepository   RegistrationRepository
+	tokenRepository TokenRepository
+	hasher          PasswordHasher
+	mailer          notify.Mailer
+	sessions        SessionRevoker
+	auditor         audit.Service
+	tx              storage.Transactor
+	logger          kitlog.Logger
 }
 
-func NewService(repo RegistrationRepository) Service {
+// NewService returns the registration service. Every change to an account is
+// recorded with auditor in the same unit of work of tx as the change itself,
+// so tx must cover every repository given as well as the audit log's. Mail
+// that fails to go out after a change is stored is only reported to logger.
+func NewService(repo RegistrationRepository, tokens TokenRepository,
+	hasher PasswordHasher, mailer notify.Mailer, sessions SessionRevoker,
+	auditor audit.Service, tx storage.Transactor, logger kitlog.Logger) Service {
 	return &service{
-		regRepository: repo,
+		regRepository:   repo,
+		tokenRepository: tokens,
+		hasher:          hasher,
+		mailer:          mailer,
+		sessions:        sessions,
+		auditor:         auditor,
+		tx:              tx,
+		logger:          logger,
 	}
 }
 
-func (s *service) NewRegistration(username string, password []byte, email string,
+func (s *service) NewRegistration(ctx context.Context, username string, password []byte, email string,
 	shortBio string, timezone string) (*model.Registration, error) {
-	if username == "" || len(password) == 0 || email == "" {
-		return nil, ErrInvalidArgument
-	}
-
 	if timezone == "" {
 		timezone = "UTC"
 	}
 
+	v := &ValidationError{}
+	validateUsername(v, username)
+	validatePassword(v, password, username)
+	validateEmail(v, email)
+	validateTimeZone(v, timezone)
+	validateShortBio(v, shortBio)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	other, err := s.regRepository.FindByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if other == nil {
+		if other, err = s.regRepository.FindByEmail(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+	if other != nil {
+		return nil, ErrRegistrationExists
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
 	newId, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
@@ -57,73 +156,479 @@ func (s *service) NewRegistration(username string, password []byte, email string
 		Id:        newId,
 		Name:      username,
 		Email:     email,
-		Password:  password,
+		Password:  hash,
 		ShortBio:  shortBio,
 		Validated: false,
 		TimeZone:  timezone,
+		Role:      model.RolePlayer,
+		Status:    model.StatusActive,
 	}
 
-	storedReg, err := s.regRepository.Store(newReg)
+	// Mail is only sent once the account and its token are committed
+	var storedReg *model.Registration
+	var token string
+	err = s.tx.Within(ctx, func(ctx context.Context) error {
+		var err error
+		if storedReg, err = s.regRepository.Create(ctx, newReg); err != nil {
+			return err
+		}
+		token, err = s.issueToken(ctx, storedReg.Id, model.TokenPurposeVerifyEmail,
+			DefaultVerificationTTL)
+		if err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, model.AuditActionCreate, storedReg.Id,
+			createChanges(storedReg))
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	s.mailStoredVerification(ctx, storedReg, token)
 	return storedReg, nil
 }
 
-func (s *service) EditRegistration(id uuid.UUID, username string, password []byte,
-	email string, shortBio string, timezone string, validated bool) (*model.Registration, error) {
-	if len(id) == 0 {
-		return nil, errors.Unwrap(fmt.Errorf("%w - Must provide a UUID",
-			ErrInvalidArgument))
+func (s *service) EditRegistration(ctx context.Context, id uuid.UUID,
+	patch RegistrationPatch) (*model.Registration, error) {
+	if id == uuid.Nil {
+		return nil, ErrInvalidArgument.Withf("Must provide a UUID")
 	}
 
-	reg := s.regRepository.Find(id)
+	reg, err := s.regRepository.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 	if reg == nil {
-		return nil, errors.Unwrap(fmt.Errorf("%w - for id %v",
-			ErrRegistrationNotFound, id))
+		return nil, ErrRegistrationNotFound.Withf("for id %v", id)
+	}
+	if patch.IfVersion != 0 && patch.IfVersion != reg.Version {
+		return nil, ErrPreconditionFailed.Withf("version is %d", reg.Version)
+	}
+
+	v := &ValidationError{}
+	if patch.Username != nil {
+		validateUsername(v, *patch.Username)
+	}
+	if patch.Password != nil {
+		username := reg.Name
+		if patch.Username != nil {
+			username = *patch.Username
+		}
+		validatePassword(v, patch.Password, username)
+	}
+	if patch.Email != nil {
+		validateEmail(v, *patch.Email)
+	}
+	if patch.TimeZone != nil && *patch.TimeZone != "" {
+		validateTimeZone(v, *patch.TimeZone)
+	}
+	if patch.ShortBio != nil {
+		validateShortBio(v, *patch.ShortBio)
+	}
+	if patch.Validated != nil && *patch.Validated {
+		v.add("validated", "can only be cleared, confirm the email address to set it")
+	}
+	if patch.Role != nil {
+		validateRole(v, *patch.Role)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
-	if username != "" {
-		reg.Name = username
+	if patch.Username != nil && !strings.EqualFold(*patch.Username, reg.Name) {
+		other, err := s.regRepository.FindByName(ctx, *patch.Username)
+		if err != nil {
+			return nil, err
+		}
+		if other != nil && other.Id != reg.Id {
+			return nil, ErrRegistrationExists
+		}
 	}
-	if len(password) > 0 {
-		reg.Password = password
+	emailChanged := patch.Email != nil && !strings.EqualFold(*patch.Email, reg.Email)
+	if emailChanged {
+		other, err := s.regRepository.FindByEmail(ctx, *patch.Email)
+		if err != nil {
+			return nil, err
+		}
+		if other != nil && other.Id != reg.Id {
+			return nil, ErrRegistrationExists
+		}
+	}
+
+	if patch.Username != nil {
+		reg.Name = *patch.Username
 	}
-	if email != "" {
-		reg.Email = email
+	if patch.Password != nil {
+		hash, err := s.hasher.Hash(patch.Password)
+		if err != nil {
+			return nil, err
+		}
+		reg.Password = hash
 	}
-	if shortBio != "" {
-		reg.ShortBio = shortBio
+	if patch.Email != nil {
+		reg.Email = *patch.Email
 	}
-	if timezone != "" {
-		reg.TimeZone = timezone
+	if emailChanged || patch.Validated != nil {
+		reg.Validated = false
 	}
-	if validated != false {
-		reg.Validated = validated
+	if patch.ShortBio != nil {
+		reg.ShortBio = *patch.ShortBio
+	}
+	if patch.Role != nil {
+		reg.Role = *patch.Role
+	}
+	if patch.TimeZone != nil {
+		reg.TimeZone = *patch.TimeZone
+		if reg.TimeZone == "" {
+			reg.TimeZone = "UTC"
+		}
 	}
 
-	storedReg, err := s.regRepository.Store(reg)
+	var storedReg *model.Registration
+	var token string
+	err = s.tx.Within(ctx, func(ctx context.Context) error {
+		var err error
+		if storedReg, err = s.regRepository.Update(ctx, reg); err != nil {
+			return err
+		}
+		if emailChanged {
+			token, err = s.issueToken(ctx, storedReg.Id, model.TokenPurposeVerifyEmail,
+				DefaultVerificationTTL)
+			if err != nil {
+				return err
+			}
+		}
+		return s.auditor.Record(ctx, model.AuditActionEdit, storedReg.Id,
+			editChanges(patch, storedReg))
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	if emailChanged {
+		s.mailStoredVerification(ctx, storedReg, token)
+	}
+
 	return storedReg, nil
 }
 
-func (s *service) DeleteRegistration(id uuid.UUID) error {
-	if len(id) == 0 {
-		return errors.Unwrap(fmt.Errorf("%w - Must provide a UUID",
-			ErrInvalidArgument))
+func (s *service) ConfirmEmail(ctx context.Context, token string) (*model.Registration, error) {
+	var storedReg *model.Registration
+	err := s.tx.Within(ctx, func(ctx context.Context) error {
+		id, err := s.consumeToken(ctx, token, model.TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+
+		reg, err := s.regRepository.Find(ctx, id)
+		if err != nil {
+			return err
+		}
+		if reg == nil {
+			return ErrInvalidToken
+		}
+
+		reg.Validated = true
+		if storedReg, err = s.regRepository.Update(ctx, reg); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, model.AuditActionValidate, storedReg.Id,
+			audit.Changes{"validated": true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storedReg, nil
+}
+
+func (s *service) ResendVerification(ctx context.Context, email string) error {
+	if email == "" {
+		return ErrInvalidArgument
+	}
+
+	reg, err := s.regRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if reg == nil || reg.Validated {
+		// Do not reveal whether an address is registered
+		return nil
 	}
 
-	return s.regRepository.Delete(id)
+	return s.sendVerification(ctx, reg)
 }
 
-func (s *service) AllRegistrations() []*model.Registration {
-	return s.regRepository.FindAll()
+func (s *service) RequestPasswordReset(ctx context.Context, email string) error {
+	if email == "" {
+		return ErrInvalidArgument
+	}
+
+	reg, err := s.regRepository.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if reg == nil {
+		// Do not reveal whether an address is registered
+		return nil
+	}
+
+	token, err := s.issueToken(ctx, reg.Id, model.TokenPurposeResetPassword,
+		DefaultPasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, notify.Message{
+		To:      reg.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello, %s.\n\nUse this code to choose a new "+
+			"password: %s\n\nThe code expires in %v. If you did not ask to "+
+			"reset your password you can ignore this message.", reg.Name, token,
+			DefaultPasswordResetTTL),
+	})
 }
 
-func (s *service) FindById(id uuid.UUID) *model.Registration {
-	return s.regRepository.Find(id)
+func (s *service) ResetPassword(ctx context.Context, token string, password []byte) error {
+	v := &ValidationError{}
+	validatePassword(v, password, "")
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	return s.tx.Within(ctx, func(ctx context.Context) error {
+		id, err := s.consumeToken(ctx, token, model.TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		reg, err := s.regRepository.Find(ctx, id)
+		if err != nil {
+			return err
+		}
+		if reg == nil {
+			return ErrInvalidToken
+		}
+
+		v := &ValidationError{}
+		validatePassword(v, password, reg.Name)
+		if err := v.err(); err != nil {
+			return err
+		}
+
+		hash, err := s.hasher.Hash(password)
+		if err != nil {
+			return err
+		}
+		reg.Password = hash
+
+		if _, err := s.regRepository.Update(ctx, reg); err != nil {
+			return err
+		}
+
+		if err := s.sessions.DeleteByAccount(ctx, reg.Id); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, model.AuditActionPasswordReset, reg.Id,
+			audit.Changes{"password": audit.Redacted})
+	})
+}
+
+func (s *service) sendVerification(ctx context.Context, reg *model.Registration) error {
+	token, err := s.issueToken(ctx, reg.Id, model.TokenPurposeVerifyEmail,
+		DefaultVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailVerification(ctx, reg, token)
+}
+
+func (s *service) mailVerification(ctx context.Context, reg *model.Registration, token string) error {
+	return s.mailer.Send(ctx, notify.Message{
+		To:      reg.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome, %s!\n\nUse this code to verify your email "+
+			"address: %s\n\nThe code expires in %v.", reg.Name, token,
+			DefaultVerificationTTL),
+	})
+}
+
+// mailStoredVerification mails a token issued by a change that is already
+// committed. Failing the request then would only make the client retry a
+// change that was made, so failures are logged and the owner can ask for
+// another token instead.
+func (s *service) mailStoredVerification(ctx context.Context, reg *model.Registration, token string) {
+	if err := s.mailVerification(ctx, reg, token); err != nil {
+		s.logger.Log("msg", "mailing verification failed", "id", reg.Id, "err", err)
+	}
+}
+
+func (s *service) DeleteRegistration(ctx context.Context, id uuid.UUID, reason string) error {
+	return s.DeactivateRegistration(ctx, id, model.StatusDeleted, reason)
+}
+
+func (s *service) DeactivateRegistration(ctx context.Context, id uuid.UUID, status string,
+	reason string) error {
+	if id == uuid.Nil {
+		return ErrInvalidArgument.Withf("Must provide a UUID")
+	}
+
+	v := &ValidationError{}
+	validateDeactivation(v, status, reason)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	return s.tx.Within(ctx, func(ctx context.Context) error {
+		// Moderators may still turn a deleted account into a banned one
+		reg, err := s.regRepository.FindAny(ctx, id)
+		if err != nil {
+			return err
+		}
+		if reg == nil {
+			return ErrRegistrationNotFound.Withf("for id %v", id)
+		}
+
+		now := time.Now().UTC()
+		reg.Status = status
+		reg.StatusReason = reason
+		reg.StatusChangedAt = sql.NullTime{Time: now, Valid: true}
+		if _, err := s.regRepository.Update(ctx, reg); err != nil {
+			return err
+		}
+
+		if err := s.sessions.DeleteByAccount(ctx, reg.Id); err != nil {
+			return err
+		}
+
+		action := model.AuditActionDeactivate
+		if status == model.StatusDeleted {
+			action = model.AuditActionDelete
+		}
+		return s.auditor.Record(ctx, action, reg.Id, audit.Changes{
+			"status": status,
+			"reason": reason,
+		})
+	})
+}
+
+func (s *service) RestoreRegistration(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	reg, err := s.regRepository.FindAny(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if reg == nil {
+		return nil, ErrRegistrationNotFound.Withf("for id %v", id)
+	}
+	if reg.Status == model.StatusActive {
+		return nil, ErrInvalidArgument.Withf("registration %v is active", id)
+	}
+
+	now := time.Now().UTC()
+	reg.Status = model.StatusActive
+	reg.StatusReason = ""
+	reg.StatusChangedAt = sql.NullTime{Time: now, Valid: true}
+
+	var storedReg *model.Registration
+	err = s.tx.Within(ctx, func(ctx context.Context) error {
+		var err error
+		if storedReg, err = s.regRepository.Update(ctx, reg); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, model.AuditActionRestore, storedReg.Id,
+			audit.Changes{"status": model.StatusActive})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storedReg, nil
+}
+
+func (s *service) PurgeRegistrations(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var purged []uuid.UUID
+	err := s.tx.Within(ctx, func(ctx context.Context) error {
+		var err error
+		if purged, err = s.regRepository.Purge(ctx, deletedBefore); err != nil {
+			return err
+		}
+		for _, id := range purged {
+			if err := s.auditor.Record(ctx, model.AuditActionPurge, id, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(purged), nil
+}
+
+func (s *service) ListRegistrations(ctx context.Context, opts ListOptions) (*RegistrationPage, error) {
+	query, err := queryFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ask for one more than a page to learn whether another page follows
+	limit := query.Limit
+	query.Limit++
+	regs, err := s.regRepository.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &RegistrationPage{Registrations: regs}
+	if len(regs) > limit {
+		page.Registrations = regs[:limit]
+		last := page.Registrations[limit-1]
+		page.NextCursor = encodeCursor(RegistrationCursor{
+			SortBy:     query.SortBy,
+			Descending: query.Descending,
+			Key:        SortKey(last, query.SortBy),
+			Id:         last.Id,
+		})
+	}
+
+	return page, nil
+}
+
+func (s *service) FindById(ctx context.Context, id uuid.UUID) (*model.Registration, error) {
+	return s.regRepository.Find(ctx, id)
+}
+
+func (s *service) Authenticate(ctx context.Context, username string, password []byte) (*model.Registration, error) {
+	if username == "" || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	reg, err := s.regRepository.FindByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if reg == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := s.hasher.Compare(reg.Password, password); err != nil {
+		if errors.Is(err, ErrPasswordMismatch) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	// The password is known to be correct here, so take the chance to bring
+	// its hash up to the current policy. Failing to do so must not fail
+	// the login itself.
+	if s.hasher.NeedsRehash(reg.Password) {
+		if hash, err := s.hasher.Hash(password); err == nil {
+			reg.Password = hash
+			if storedReg, err := s.regRepository.Update(ctx, reg); err == nil {
+				reg = storedReg
+			}
+		}
+	}
+
+	return reg, nil
 }
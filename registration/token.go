@@ -0,0 +1,85 @@
+package registration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/angelcaban/mud/model"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	DefaultVerificationTTL  = 48 * time.Hour
+	DefaultPasswordResetTTL = time.Hour
+	accountTokenBytes       = 32
+)
+
+// issueToken replaces any outstanding token of the same purpose for an account
+// with a new one. The plain token is returned to be handed to the account
+// holder; only its digest is stored.
+func (s *service) issueToken(ctx context.Context, accountId uuid.UUID, purpose string,
+	ttl time.Duration) (string, error) {
+	raw := make([]byte, accountTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	newId, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.tokenRepository.DeleteByAccount(ctx, accountId, purpose); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	_, err = s.tokenRepository.Store(ctx, &model.AccountToken{
+		Id:        newId,
+		AccountId: accountId,
+		Purpose:   purpose,
+		TokenHash: hashAccountToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeToken resolves a plain token into the account it was issued for and
+// removes it, so every token can only be used once.
+func (s *service) consumeToken(ctx context.Context, token string, purpose string) (uuid.UUID, error) {
+	if token == "" {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	stored, err := s.tokenRepository.FindByTokenHash(ctx, purpose, hashAccountToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if stored == nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	if err := s.tokenRepository.Delete(ctx, stored.Id); err != nil {
+		return uuid.Nil, err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	return stored.AccountId, nil
+}
+
+func hashAccountToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
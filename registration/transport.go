@@ -5,7 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/problem"
+	"github.com/angelcaban/mud/ratelimit"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 
@@ -14,116 +20,471 @@ import (
 	kithttp "github.com/go-kit/kit/transport/http"
 )
 
-var ErrBadRoute = errors.New("Bad Route")
+var ErrBadRoute = apperr.New(apperr.CodeMalformedRequest, http.StatusBadRequest,
+	"Bad Route")
+var ErrMalformedRequest = apperr.New(apperr.CodeMalformedRequest, http.StatusBadRequest,
+	"Malformed Request")
 
-func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
+// idPattern restricts {id} route variables to UUIDs so that they never shadow
+// fixed routes such as /v1/registrations/verify.
+const idPattern = "{id:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}"
+
+// RateLimits bounds how often each client may call groups of endpoints. Zero
+// limits leave a group unlimited.
+type RateLimits struct {
+	// New registrations, per address
+	Signup ratelimit.Limit
+
+	// Email verification and password resets, per address
+	Recovery ratelimit.Limit
+
+	// Every other endpoint, per account or, for anonymous callers, address
+	API ratelimit.Limit
+}
+
+// MakeHandler serves the registration API, identifying callers by the bearer
+// tokens resolve accepts and limiting them with limiter. With legacyRoutes set
+// it also serves the deprecated POST /v1/registrations/update and ?id= routes.
+func MakeHandler(s Service, logger kitlog.Logger, resolve auth.Resolver,
+	limiter *ratelimit.Limiter, limits RateLimits, legacyRoutes bool) http.Handler {
 	opts := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext, auth.HTTPToContext(resolve)),
 	}
 
+	signupLimit := limiter.Limit("signup", limits.Signup, ratelimit.ByIP)
+	recoveryLimit := limiter.Limit("recovery", limits.Recovery, ratelimit.ByIP)
+	apiLimit := limiter.Limit("registrations", limits.API, ratelimit.ByAccount)
+
+	// Public endpoints still reject invalid bearer tokens
+	public := auth.Authorize(auth.Anyone)
+
+	editRegistrationEndpoint := apiLimit(auth.Authorize(selfOrAdmin)(makeEditRegistrationEndpoint(s)))
+
 	newRegistrationHandler := kithttp.NewServer(
-		makeNewRegistrationEndpoint(s),
+		signupLimit(public(makeNewRegistrationEndpoint(s))),
 		decodeNewRegistrationRequest,
 		encodeResponse,
 		opts...,
 	)
 
-	updateRegistrationHandler := kithttp.NewServer(
-		makeUpdateRegistrationEndpoint(s),
-		decodeUpdateRegistrationRequest,
+	replaceRegistrationHandler := kithttp.NewServer(
+		editRegistrationEndpoint,
+		decodeReplaceRegistrationRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	patchRegistrationHandler := kithttp.NewServer(
+		editRegistrationEndpoint,
+		decodePatchRegistrationRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	confirmEmailHandler := kithttp.NewServer(
+		recoveryLimit(public(makeConfirmEmailEndpoint(s))),
+		decodeConfirmEmailRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	resendVerificationHandler := kithttp.NewServer(
+		recoveryLimit(public(makeResendVerificationEndpoint(s))),
+		decodeResendVerificationRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	requestPasswordResetHandler := kithttp.NewServer(
+		recoveryLimit(public(makeRequestPasswordResetEndpoint(s))),
+		decodeRequestPasswordResetRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	resetPasswordHandler := kithttp.NewServer(
+		recoveryLimit(public(makeResetPasswordEndpoint(s))),
+		decodeResetPasswordRequest,
 		encodeResponse,
 		opts...,
 	)
 
 	deleteRegistrationHandler := kithttp.NewServer(
-		makeDeleteRegistrationEndpoint(s),
+		apiLimit(auth.Authorize(selfOrAdmin)(makeDeleteRegistrationEndpoint(s))),
+		decodeDeleteRegistrationRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	deactivateRegistrationHandler := kithttp.NewServer(
+		apiLimit(auth.Authorize(auth.Admin)(makeDeactivateRegistrationEndpoint(s))),
+		decodeDeactivateRegistrationRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	restoreRegistrationHandler := kithttp.NewServer(
+		apiLimit(auth.Authorize(auth.Admin)(makeRestoreRegistrationEndpoint(s))),
 		decodeRequestWithId,
 		encodeResponse,
 		opts...,
 	)
 
 	getRegistrationHandler := kithttp.NewServer(
-		makeGetRegistrationEndpoint(s),
+		apiLimit(public(makeGetRegistrationEndpoint(s))),
 		decodeRequestWithId,
 		encodeResponse,
 		opts...,
 	)
 
 	getAllRegistrationsHandler := kithttp.NewServer(
-		makeGetAllRegistrationsEndpoint(s),
-		decodeRequest,
+		apiLimit(auth.Authorize(auth.Admin)(makeGetAllRegistrationsEndpoint(s))),
+		decodeGetAllRegistrationsRequest,
 		encodeResponse,
 		opts...,
 	)
 
 	r := mux.NewRouter()
 
+	if legacyRoutes {
+		updateRegistrationHandler := kithttp.NewServer(
+			editRegistrationEndpoint,
+			decodeUpdateRegistrationRequest,
+			encodeResponse,
+			opts...,
+		)
+
+		r.Handle("/v1/registrations", getRegistrationHandler).
+			Queries("id", idPattern).Methods("GET")
+		r.Handle("/v1/registrations", deleteRegistrationHandler).
+			Queries("id", idPattern).Methods("DELETE")
+		r.Handle("/v1/registrations/update", updateRegistrationHandler).Methods("POST")
+	}
+
 	r.Handle("/v1/registrations", newRegistrationHandler).Methods("POST")
 	r.Handle("/v1/registrations", getAllRegistrationsHandler).Methods("GET")
-	r.Handle("/v1/registrations?id={id}", getRegistrationHandler).Methods("GET")
-	r.Handle("/v1/registrations?id={id}", deleteRegistrationHandler).Methods("DELETE")
-	r.Handle("/v1/registrations/update", updateRegistrationHandler).Methods("POST")
+	r.Handle("/v1/registrations/verify", confirmEmailHandler).Methods("POST")
+	r.Handle("/v1/registrations/verify/resend", resendVerificationHandler).Methods("POST")
+	r.Handle("/v1/registrations/password-reset", requestPasswordResetHandler).Methods("POST")
+	r.Handle("/v1/registrations/password-reset/confirm", resetPasswordHandler).Methods("POST")
+	r.Handle("/v1/registrations/"+idPattern, getRegistrationHandler).Methods("GET")
+	r.Handle("/v1/registrations/"+idPattern, replaceRegistrationHandler).Methods("PUT")
+	r.Handle("/v1/registrations/"+idPattern, patchRegistrationHandler).Methods("PATCH")
+	r.Handle("/v1/registrations/"+idPattern, deleteRegistrationHandler).Methods("DELETE")
+	r.Handle("/v1/registrations/"+idPattern+"/deactivate", deactivateRegistrationHandler).Methods("POST")
+	r.Handle("/v1/registrations/"+idPattern+"/restore", restoreRegistrationHandler).Methods("POST")
 
 	return r
 }
 
 func decodeNewRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	request := NewRegistrationRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeJSON(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
 }
 
+// decodeUpdateRegistrationRequest keeps the semantics of the legacy update
+// route, where empty fields are left untouched.
 func decodeUpdateRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	request := EditRegistrationRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+
+	patch := RegistrationPatch{}
+	if request.Username != "" {
+		patch.Username = &request.Username
+	}
+	if len(request.PasswordEnc) > 0 {
+		patch.Password = request.PasswordEnc
+	}
+	if request.Email != "" {
+		patch.Email = &request.Email
+	}
+	if request.ShortBio != "" {
+		patch.ShortBio = &request.ShortBio
+	}
+	if request.TimeZone != "" {
+		patch.TimeZone = &request.TimeZone
+	}
+	return PatchRegistrationRequest{Id: request.Id, Patch: patch}, nil
+}
+
+func decodeReplaceRegistrationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRoute(r)
+	if err != nil {
+		return nil, err
+	}
+
+	request := ReplaceRegistrationRequest{}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+
+	ifVersion, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := RegistrationPatch{
+		IfVersion: ifVersion,
+		Username:  &request.Username,
+		Email:     &request.Email,
+		ShortBio:  &request.ShortBio,
+		TimeZone:  &request.TimeZone,
+		Validated: request.Validated,
+		Role:      request.Role,
+	}
+	if request.PasswordEnc != nil {
+		patch.Password = request.PasswordEnc
+	}
+	return PatchRegistrationRequest{Id: id, Patch: patch}, nil
+}
+
+// decodePatchRegistrationRequest reads a JSON Merge Patch (RFC 7396). Members
+// that are absent stay untouched, while an explicit null resets the optional
+// fields shortbio, timezone and validated.
+func decodePatchRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRoute(r)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]json.RawMessage{}
+	if err := decodeJSON(r, &members); err != nil {
+		return nil, err
+	}
+
+	ifVersion, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ValidationError{}
+	patch := RegistrationPatch{IfVersion: ifVersion}
+	for name, raw := range members {
+		isNull := string(raw) == "null"
+		switch name {
+		case "username", "password", "email", "role":
+			if isNull {
+				v.add(name, "must not be null")
+				continue
+			}
+		}
+
+		switch name {
+		case "username":
+			patch.Username = new(string)
+			err = json.Unmarshal(raw, patch.Username)
+		case "password":
+			err = json.Unmarshal(raw, &patch.Password)
+		case "email":
+			patch.Email = new(string)
+			err = json.Unmarshal(raw, patch.Email)
+		case "shortbio":
+			patch.ShortBio = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, patch.ShortBio)
+			}
+		case "timezone":
+			patch.TimeZone = new(string)
+			if !isNull {
+				err = json.Unmarshal(raw, patch.TimeZone)
+			}
+		case "validated":
+			patch.Validated = new(bool)
+			if !isNull {
+				err = json.Unmarshal(raw, patch.Validated)
+			}
+		case "role":
+			patch.Role = new(string)
+			err = json.Unmarshal(raw, patch.Role)
+		default:
+			v.add(name, "is not a registration field")
+			continue
+		}
+		if err != nil {
+			v.add(name, "has the wrong type")
+			err = nil
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	return PatchRegistrationRequest{Id: id, Patch: patch}, nil
+}
+
+func decodeConfirmEmailRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := ConfirmEmailRequest{}
+	if err := decodeJSON(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
 }
 
-func decodeRequestWithId(_ context.Context, r *http.Request) (interface{}, error) {
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		return nil, ErrBadRoute
+func decodeResendVerificationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := ResendVerificationRequest{}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeRequestPasswordResetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := RequestPasswordResetRequest{}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeResetPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := ResetPasswordRequest{}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
 	}
+	return request, nil
+}
 
-	byteId, err := uuid.FromString(id)
+func decodeDeleteRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRoute(r)
 	if err != nil {
 		return nil, err
 	}
 
-	request := RegistrationRequestWithId{Id: byteId}
+	return DeleteRegistrationRequest{Id: id, Reason: r.URL.Query().Get("reason")}, nil
+}
+
+func decodeDeactivateRegistrationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRoute(r)
+	if err != nil {
+		return nil, err
+	}
+
+	request := DeactivateRegistrationRequest{}
+	if err := decodeJSON(r, &request); err != nil {
+		return nil, err
+	}
+	request.Id = id
 	return request, nil
 }
 
-func decodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var s struct{}
-	return s, nil
+func decodeRequestWithId(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := idFromRoute(r)
+	if err != nil {
+		return nil, err
+	}
+
+	request := RegistrationRequestWithId{Id: id}
+	return request, nil
+}
+
+// decodeJSON reads a request body into v, telling a malformed body apart
+// from invalid field values.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return ErrMalformedRequest.Withf("%v", err)
+	}
+	return nil
+}
+
+// ifMatchVersion returns the registration version an If-Match header asks
+// for, or zero if any version will do. Only single strong ETags as sent by
+// etag can ever match.
+func ifMatchVersion(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) ||
+		!strings.HasSuffix(header, `"`) {
+		return 0, ErrPreconditionFailed.Withf("unknown entity tag %s", header)
+	}
+	return version, nil
+}
+
+// etag is the entity tag of a registration version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func idFromRoute(r *http.Request) (uuid.UUID, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return uuid.Nil, ErrBadRoute
+	}
+
+	return uuid.FromString(id)
+}
+
+// decodeGetAllRegistrationsRequest reads the listing options from the query
+// string, e.g. ?limit=20&validated=true&name_prefix=al&sort=-email&cursor=...
+func decodeGetAllRegistrationsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	opts := ListOptions{
+		Cursor:      q.Get("cursor"),
+		Status:      q.Get("status"),
+		EmailDomain: q.Get("email_domain"),
+		NamePrefix:  q.Get("name_prefix"),
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, ErrInvalidArgument.Withf("limit must be an integer")
+		}
+		opts.Limit = n
+	}
+
+	if validated := q.Get("validated"); validated != "" {
+		v, err := strconv.ParseBool(validated)
+		if err != nil {
+			return nil, ErrInvalidArgument.Withf("validated must be a boolean")
+		}
+		opts.Validated = &v
+	}
+
+	if sortBy := q.Get("sort"); sortBy != "" {
+		opts.Descending = strings.HasPrefix(sortBy, "-")
+		opts.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+
+	return GetAllRegistrationsRequest{Options: opts}, nil
 }
 
 type errorer interface {
 	error() error
 }
 
+// viewer is implemented by responses whose representation depends on who
+// asked for them.
+type viewer interface {
+	view(p *auth.Principal) interface{}
+}
+
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	switch err {
-	case ErrInvalidArgument:
-		w.WriteHeader(http.StatusNotFound)
-	case ErrRegistrationExists:
-		w.WriteHeader(http.StatusNotAcceptable)
-	case ErrRegistrationNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+	details := problem.FromError(err)
+	if details.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		for _, field := range validationErr.Fields {
+			details.InvalidParams = append(details.InvalidParams,
+				problem.InvalidParam{Name: field.Field, Reason: field.Reason})
+		}
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	problem.Write(w, details)
 }
 
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
@@ -134,5 +495,24 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 		return nil
 	}
 
+	if h, ok := response.(kithttp.Headerer); ok {
+		for k, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+
+	if sc, ok := response.(kithttp.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+		if sc.StatusCode() == http.StatusNoContent {
+			return nil
+		}
+	}
+
+	if v, ok := response.(viewer); ok {
+		response = v.view(auth.FromContext(ctx))
+	}
+
 	return json.NewEncoder(w).Encode(response)
 }
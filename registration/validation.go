@@ -0,0 +1,157 @@
+package registration
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/angelcaban/mud/model"
+)
+
+// Limits enforced on registration input
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MaxEmailLength    = 254
+	MaxShortBioLength = 500
+	MinPasswordLength = 8
+	MaxReasonLength   = 255
+
+	// bcrypt ignores everything past its first 72 bytes
+	MaxPasswordLength = 72
+)
+
+// FieldError describes why a single input field was rejected.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+// ValidationError lists every field of a request that failed validation, so
+// that clients can report them all at once. It wraps ErrValidationFailed and
+// also matches ErrInvalidArgument.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		reasons[i] = field.Field + ": " + field.Reason
+	}
+	return ErrValidationFailed.Error() + " (" + strings.Join(reasons, "; ") + ")"
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidationFailed
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return errors.Is(ErrInvalidArgument, target)
+}
+
+func (e *ValidationError) add(field, reason string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Reason: reason})
+}
+
+// err returns the validation error, or nil if no field was rejected.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func validateUsername(v *ValidationError, username string) {
+	length := utf8.RuneCountInString(username)
+	if length < MinUsernameLength || length > MaxUsernameLength {
+		v.add("username", fmt.Sprintf("must be between %d and %d characters long",
+			MinUsernameLength, MaxUsernameLength))
+		return
+	}
+
+	for i, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '_' || c == '-'):
+		default:
+			v.add("username", "must start with a letter and contain only letters, "+
+				"digits, '_' and '-'")
+			return
+		}
+	}
+}
+
+func validateEmail(v *ValidationError, email string) {
+	if len(email) > MaxEmailLength {
+		v.add("email", fmt.Sprintf("must be at most %d characters long", MaxEmailLength))
+		return
+	}
+
+	// A bare addr-spec only, without a display name or angle brackets
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		v.add("email", "must be a valid email address")
+	}
+}
+
+func validatePassword(v *ValidationError, password []byte, username string) {
+	switch {
+	case len(password) < MinPasswordLength:
+		v.add("password", fmt.Sprintf("must be at least %d bytes long", MinPasswordLength))
+	case len(password) > MaxPasswordLength:
+		v.add("password", fmt.Sprintf("must be at most %d bytes long", MaxPasswordLength))
+	case username != "" && strings.EqualFold(string(password), username):
+		v.add("password", "must not be the username")
+	}
+}
+
+func validateTimeZone(v *ValidationError, timezone string) {
+	// LoadLocation also accepts "Local", which means nothing to a client
+	if timezone == "Local" {
+		v.add("timezone", "must be an IANA time zone name")
+		return
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		v.add("timezone", "must be an IANA time zone name")
+	}
+}
+
+func validateShortBio(v *ValidationError, shortBio string) {
+	if !utf8.ValidString(shortBio) {
+		v.add("shortbio", "must be valid UTF-8")
+		return
+	}
+	if utf8.RuneCountInString(shortBio) > MaxShortBioLength {
+		v.add("shortbio", fmt.Sprintf("must be at most %d characters long", MaxShortBioLength))
+	}
+}
+
+func validateRole(v *ValidationError, role string) {
+	switch role {
+	case model.RolePlayer, model.RoleBuilder, model.RoleAdmin:
+	default:
+		v.add("role", fmt.Sprintf("must be one of %s, %s or %s",
+			model.RolePlayer, model.RoleBuilder, model.RoleAdmin))
+	}
+}
+
+func validateDeactivation(v *ValidationError, status string, reason string) {
+	switch status {
+	case model.StatusDeleted:
+	case model.StatusBanned, model.StatusSuspended:
+		if strings.TrimSpace(reason) == "" {
+			v.add("reason", "must be given when banning or suspending an account")
+		}
+	default:
+		v.add("status", fmt.Sprintf("must be one of %s, %s or %s",
+			model.StatusDeleted, model.StatusBanned, model.StatusSuspended))
+	}
+
+	if utf8.RuneCountInString(reason) > MaxReasonLength {
+		v.add("reason", fmt.Sprintf("must be at most %d characters long", MaxReasonLength))
+	}
+}
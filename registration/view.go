@@ -0,0 +1,72 @@
+package registration
+
+import (
+	"time"
+
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/model"
+	"github.com/gofrs/uuid"
+)
+
+// PublicRegistration is what any player may see of another account.
+type PublicRegistration struct {
+	Id       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	ShortBio string    `json:"shortbio,omitempty"`
+}
+
+// SelfRegistration is what account holders see of their own account.
+type SelfRegistration struct {
+	Id        uuid.UUID `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	TimeZone  string    `json:"timezone"`
+	ShortBio  string    `json:"shortbio,omitempty"`
+	Validated bool      `json:"validated"`
+}
+
+// AdminRegistration is what administrators see of any account.
+type AdminRegistration struct {
+	SelfRegistration
+	Role            string     `json:"role"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+}
+
+// RegistrationView picks the representation of reg the principal p may see.
+// Password hashes are never part of any of them.
+func RegistrationView(p *auth.Principal, reg *model.Registration) interface{} {
+	switch {
+	case p.IsAdmin():
+		view := AdminRegistration{
+			SelfRegistration: selfView(reg),
+			Role:             reg.Role,
+			Status:           reg.Status,
+			StatusReason:     reg.StatusReason,
+		}
+		if reg.StatusChangedAt.Valid {
+			view.StatusChangedAt = &reg.StatusChangedAt.Time
+		}
+		return view
+	case p != nil && p.AccountId == reg.Id:
+		return selfView(reg)
+	default:
+		return PublicRegistration{
+			Id:       reg.Id,
+			Username: reg.Name,
+			ShortBio: reg.ShortBio,
+		}
+	}
+}
+
+func selfView(reg *model.Registration) SelfRegistration {
+	return SelfRegistration{
+		Id:        reg.Id,
+		Username:  reg.Name,
+		Email:     reg.Email,
+		TimeZone:  reg.TimeZone,
+		ShortBio:  reg.ShortBio,
+		Validated: reg.Validated,
+	}
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gofrs/uuid"
+)
+
+type LoginRequest struct {
+	Username    string `json:"username"`
+	PasswordEnc []byte `json:"password"`
+}
+
+type LoginResponse struct {
+	Token     string    `json:"token,omitempty"`
+	AccountId uuid.UUID `json:"account_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Err       error     `json:"error,omitempty"`
+}
+
+type LogoutRequest struct {
+	Token string `json:"-"`
+}
+
+type LogoutResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r LoginResponse) error() error {
+	return r.Err
+}
+
+func (r LogoutResponse) error() error {
+	return r.Err
+}
+
+func makeLoginEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LoginRequest)
+		token, session, err := s.Login(ctx, req.Username, req.PasswordEnc)
+		if err != nil {
+			return LoginResponse{Err: err}, nil
+		}
+
+		return LoginResponse{
+			Token:     token,
+			AccountId: session.AccountId,
+			ExpiresAt: session.ExpiresAt,
+			Err:       nil,
+		}, nil
+	}
+}
+
+func makeLogoutEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LogoutRequest)
+		err := s.Logout(ctx, req.Token)
+		return LogoutResponse{Err: err}, nil
+	}
+}
@@ -0,0 +1,54 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/angelcaban/mud/model"
+	"github.com/go-kit/kit/metrics"
+	"github.com/gofrs/uuid"
+)
+
+type instrumentationService struct {
+	requestCount   metrics.Counter
+	requestLatency metrics.Histogram
+	Service
+}
+
+func NewInstrumentationService(counter metrics.Counter, latency metrics.Histogram,
+	s Service) Service {
+	return &instrumentationService{counter, latency, s}
+}
+
+func (s *instrumentationService) Login(ctx context.Context, username string, password []byte) (token string,
+	session *model.Session, err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "login").Add(1)
+		s.requestLatency.With("method", "login").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.Login(ctx, username, password)
+}
+
+func (s *instrumentationService) Logout(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "logout").Add(1)
+		s.requestLatency.With("method", "logout").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.Logout(ctx, token)
+}
+
+func (s *instrumentationService) Validate(ctx context.Context, token string) (session *model.Session, err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "validate session").Add(1)
+		s.requestLatency.With("method", "validate session").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.Validate(ctx, token)
+}
+
+func (s *instrumentationService) RevokeAll(ctx context.Context, accountId uuid.UUID) (err error) {
+	defer func(begin time.Time) {
+		s.requestCount.With("method", "revoke all sessions").Add(1)
+		s.requestLatency.With("method", "revoke all sessions").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return s.Service.RevokeAll(ctx, accountId)
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/angelcaban/mud/model"
+	"github.com/go-kit/kit/log"
+	"github.com/gofrs/uuid"
+)
+
+type loggingService struct {
+	logger log.Logger
+	Service
+}
+
+func NewLoggingService(logger log.Logger, s Service) Service {
+	return &loggingService{logger, s}
+}
+
+func (s *loggingService) Login(ctx context.Context, username string, password []byte) (token string,
+	session *model.Session, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "login",
+			"username", username,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.Login(ctx, username, password)
+}
+
+func (s *loggingService) Logout(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "logout",
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.Logout(ctx, token)
+}
+
+func (s *loggingService) Validate(ctx context.Context, token string) (session *model.Session, err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "validate session",
+			"isValid", session != nil,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.Validate(ctx, token)
+}
+
+func (s *loggingService) RevokeAll(ctx context.Context, accountId uuid.UUID) (err error) {
+	defer func(begin time.Time) {
+		s.logger.Log(
+			"method", "revoke all sessions",
+			"accountId", accountId,
+			"elapsed", time.Since(begin),
+			"err", err)
+	}(time.Now())
+	return s.Service.RevokeAll(ctx, accountId)
+}
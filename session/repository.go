@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	st "github.com/Masterminds/structable"
+	"github.com/angelcaban/mud/model"
+	"github.com/angelcaban/mud/storage"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	SESSION_TABLE = "sessions"
+)
+
+type SessionRepository interface {
+	// Save a new session into the database
+	Store(ctx context.Context, session *model.Session) (*model.Session, error)
+
+	// Find a session from the database given the hash of its token, or nil if
+	// there is none
+	FindByTokenHash(ctx context.Context, tokenHash []byte) (*model.Session, error)
+
+	// Delete a session from the database given an ID
+	Delete(ctx context.Context, id uuid.UUID) error
+
+	// Delete every session belonging to an account
+	DeleteByAccount(ctx context.Context, accountId uuid.UUID) error
+}
+
+type repository struct {
+	Db         sq.DBProxyBeginner
+	DriverName string
+	Timeout    time.Duration
+}
+
+func NewSessionRepository(db *sql.DB, driverName string,
+	queryTimeout time.Duration) (SessionRepository, error) {
+	return &repository{
+		Db:         storage.NewStmtCacheProxy(db),
+		DriverName: driverName,
+		Timeout:    queryTimeout,
+	}, nil
+}
+
+func (repo *repository) Store(ctx context.Context, session *model.Session) (*model.Session, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(SESSION_TABLE, session)
+	if err := storage.Insert(ctx, rec); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (repo *repository) FindByTokenHash(ctx context.Context, tokenHash []byte) (*model.Session, error) {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	session := &model.Session{}
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(SESSION_TABLE, session)
+	if err := storage.LoadWhere(ctx, rec, sq.Eq{"token_hash": tokenHash}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, storage.Error(err)
+	}
+
+	return session, nil
+}
+
+func (repo *repository) Delete(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	session := &model.Session{Id: id}
+	rec := st.New(storage.Runner(ctx, repo.Db), repo.DriverName).Bind(SESSION_TABLE, session)
+	return storage.Delete(ctx, rec)
+}
+
+func (repo *repository) DeleteByAccount(ctx context.Context, accountId uuid.UUID) error {
+	ctx, cancel := storage.WithTimeout(ctx, repo.Timeout)
+	defer cancel()
+
+	return storage.DeleteWhere(ctx, storage.Runner(ctx, repo.Db), SESSION_TABLE, sq.Eq{"account_id": accountId})
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/angelcaban/mud/apperr"
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/model"
+	"github.com/gofrs/uuid"
+)
+
+var ErrInvalidToken = apperr.New(apperr.CodeInvalidSession,
+	http.StatusUnauthorized, "Invalid Session Token")
+var ErrSessionExpired = apperr.New(apperr.CodeSessionExpired,
+	http.StatusUnauthorized, "Session Expired")
+
+const (
+	DefaultSessionTTL = 24 * time.Hour
+	tokenBytes        = 32
+)
+
+// Authenticator verifies account credentials. It is satisfied by
+// registration.Service.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username string, password []byte) (*model.Registration, error)
+}
+
+type Service interface {
+	// Log an account in, issuing a new session token
+	Login(ctx context.Context, username string, password []byte) (token string, session *model.Session, err error)
+
+	// Revoke the session identified by a token
+	Logout(ctx context.Context, token string) error
+
+	// Resolve a token into its live session
+	Validate(ctx context.Context, token string) (*model.Session, error)
+
+	// Revoke every session belonging to an account
+	RevokeAll(ctx context.Context, accountId uuid.UUID) error
+}
+
+type service struct {
+	sessionRepository SessionRepository
+	authenticator     Authenticator
+	ttl               time.Duration
+}
+
+func NewService(repo SessionRepository, authenticator Authenticator, ttl time.Duration) Service {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &service{
+		sessionRepository: repo,
+		authenticator:     authenticator,
+		ttl:               ttl,
+	}
+}
+
+func (s *service) Login(ctx context.Context, username string, password []byte) (string, *model.Session, error) {
+	reg, err := s.authenticator.Authenticate(ctx, username, password)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	newId, err := uuid.NewV4()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	newSession := &model.Session{
+		Id:        newId,
+		AccountId: reg.Id,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	storedSession, err := s.sessionRepository.Store(ctx, newSession)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, storedSession, nil
+}
+
+func (s *service) Logout(ctx context.Context, token string) error {
+	session, err := s.Validate(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	return s.sessionRepository.Delete(ctx, session.Id)
+}
+
+func (s *service) Validate(ctx context.Context, token string) (*model.Session, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := s.sessionRepository.FindByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		s.sessionRepository.Delete(ctx, session.Id)
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+func (s *service) RevokeAll(ctx context.Context, accountId uuid.UUID) error {
+	return s.sessionRepository.DeleteByAccount(ctx, accountId)
+}
+
+// AccountFinder looks accounts up by id. It is satisfied by
+// registration.Service.
+type AccountFinder interface {
+	FindById(ctx context.Context, id uuid.UUID) (*model.Registration, error)
+}
+
+// NewResolver authenticates bearer tokens as sessions of this service. The
+// principal takes the account's current role, except for the accounts listed
+// in admins, which are always administrators.
+func NewResolver(s Service, accounts AccountFinder, admins ...uuid.UUID) auth.Resolver {
+	return func(ctx context.Context, token string) (*auth.Principal, error) {
+		session, err := s.Validate(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+
+		account, err := accounts.FindById(ctx, session.AccountId)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, ErrInvalidToken
+		}
+
+		principal := &auth.Principal{AccountId: account.Id, Role: account.Role}
+		for _, id := range admins {
+			if id == account.Id {
+				principal.Role = model.RoleAdmin
+			}
+		}
+		return principal, nil
+	}
+}
+
+// Only a digest of each token is stored, so a leaked sessions table cannot
+// be replayed against the API.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
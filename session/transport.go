@@ -0,0 +1,105 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/angelcaban/mud/auth"
+	"github.com/angelcaban/mud/problem"
+	"github.com/angelcaban/mud/ratelimit"
+	"github.com/angelcaban/mud/registration"
+	"github.com/go-kit/kit/endpoint"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+// MakeHandler serves logins and logouts, limiting login attempts from each
+// address to loginLimit and those for each username to accountLimit. The
+// latter should be generous, as anyone may spend it to lock a player out.
+func MakeHandler(s Service, logger kitlog.Logger, limiter *ratelimit.Limiter,
+	loginLimit ratelimit.Limit, accountLimit ratelimit.Limit) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(kithttp.PopulateRequestContext),
+	}
+
+	loginHandler := kithttp.NewServer(
+		endpoint.Chain(
+			limiter.Limit("login", loginLimit, ratelimit.ByIP),
+			limiter.Limit("login-account", accountLimit, byUsername),
+		)(makeLoginEndpoint(s)),
+		decodeLoginRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	logoutHandler := kithttp.NewServer(
+		makeLogoutEndpoint(s),
+		decodeLogoutRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	r := mux.NewRouter()
+
+	r.Handle("/v1/sessions", loginHandler).Methods("POST")
+	r.Handle("/v1/sessions", logoutHandler).Methods("DELETE")
+
+	return r
+}
+
+// byUsername keys login attempts by the account they try, however the
+// username is spelled, so guessing one account's password from many addresses
+// is limited too.
+func byUsername(_ context.Context, request interface{}) string {
+	req, ok := request.(LoginRequest)
+	if !ok {
+		return ""
+	}
+	name := strings.ToLower(strings.TrimSpace(req.Username))
+	if name == "" {
+		return ""
+	}
+	return "account:" + name
+}
+
+func decodeLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := LoginRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, registration.ErrMalformedRequest.Withf("%v", err)
+	}
+	return request, nil
+}
+
+func decodeLogoutRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return LogoutRequest{Token: auth.BearerToken(r)}, nil
+}
+
+type errorer interface {
+	error() error
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	details := problem.FromError(err)
+	if details.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	problem.Write(w, details)
+}
+
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	st "github.com/Masterminds/structable"
+)
+
+// DefaultQueryTimeout bounds every repository query unless configured otherwise.
+const DefaultQueryTimeout = 5 * time.Second
+
+// WithTimeout derives the context a single query runs under. A timeout of zero
+// or less leaves the caller's deadline, if any, as the only bound.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// The helpers below run the statements structable would, but through
+// squirrel's context aware methods so that queries are cancelled together
+// with the request that issued them. Except for LoadWhere, whose callers
+// need to tell sql.ErrNoRows apart, they return errors translated by Error.
+
+// LoadWhere fills a bound record from the first row matching pred.
+func LoadWhere(ctx context.Context, rec st.Recorder, pred interface{}, args ...interface{}) error {
+	return rec.Builder().
+		Select(rec.Columns(true)...).
+		From(rec.TableName()).
+		Where(pred, args...).
+		Limit(1).
+		QueryRowContext(ctx).
+		Scan(rec.FieldReferences(true)...)
+}
+
+// Insert writes a bound record as a new row.
+func Insert(ctx context.Context, rec st.Recorder) error {
+	_, err := rec.Builder().
+		Insert(rec.TableName()).
+		Columns(rec.Columns(true)...).
+		Values(fieldValues(rec, true)...).
+		ExecContext(ctx)
+	return Error(err)
+}
+
+// Update writes a bound record over the row matching its primary key and pred,
+// returning how many rows matched.
+func Update(ctx context.Context, rec st.Recorder, pred interface{}, args ...interface{}) (int64, error) {
+	columns := rec.Columns(false)
+	values := fieldValues(rec, false)
+	set := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		set[column] = values[i]
+	}
+
+	result, err := rec.Builder().
+		Update(rec.TableName()).
+		SetMap(set).
+		Where(rec.WhereIds()).
+		Where(pred, args...).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, Error(err)
+	}
+
+	updated, err := result.RowsAffected()
+	return updated, Error(err)
+}
+
+// Upsert writes a bound record as a new row or, if its primary key is taken,
+// over that row in a single statement, incrementing the counter column there
+// instead of writing it. A row clashing on another unique key fails with a
+// duplicate key error on SQLite but is silently left alone on MySQL, so
+// callers must read the row back to learn what was stored.
+func Upsert(ctx context.Context, rec st.Recorder, driver string, counter string) error {
+	columns := rec.Columns(true)
+	nonKeys := make(map[string]bool)
+	for _, column := range rec.Columns(false) {
+		nonKeys[column] = true
+	}
+
+	// Keep the column order stable, each statement text is prepared once
+	var keys, values, set []string
+	for _, column := range columns {
+		if nonKeys[column] {
+			values = append(values, column)
+		} else {
+			keys = append(keys, column)
+		}
+	}
+
+	var suffix string
+	switch driver {
+	case DriverMySQL:
+		// ON DUPLICATE KEY UPDATE fires on any unique key, so only touch the
+		// row when it is the one with the same primary key
+		same := make([]string, len(keys))
+		for i, key := range keys {
+			same[i] = key + " = VALUES(" + key + ")"
+		}
+		cond := strings.Join(same, " AND ")
+		for _, column := range values {
+			value := "VALUES(" + column + ")"
+			if column == counter {
+				value = column + " + 1"
+			}
+			set = append(set, column+" = IF("+cond+", "+value+", "+column+")")
+		}
+		suffix = "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	case DriverSQLite:
+		for _, column := range values {
+			value := "excluded." + column
+			if column == counter {
+				value = rec.TableName() + "." + column + " + 1"
+			}
+			set = append(set, column+" = "+value)
+		}
+		suffix = "ON CONFLICT(" + strings.Join(keys, ", ") + ") DO UPDATE SET " +
+			strings.Join(set, ", ")
+	default:
+		return ErrUnknownDriver
+	}
+
+	_, err := rec.Builder().
+		Insert(rec.TableName()).
+		Columns(columns...).
+		Values(fieldValues(rec, true)...).
+		Suffix(suffix).
+		ExecContext(ctx)
+	return Error(err)
+}
+
+// Ping checks that db can still run a statement.
+func Ping(ctx context.Context, db sq.BaseRunner) error {
+	var one int
+	return Error(sq.Select("1").RunWith(db).QueryRowContext(ctx).Scan(&one))
+}
+
+// Delete removes the row matching a bound record's primary key.
+func Delete(ctx context.Context, rec st.Recorder) error {
+	_, err := rec.Builder().
+		Delete(rec.TableName()).
+		Where(rec.WhereIds()).
+		ExecContext(ctx)
+	return Error(err)
+}
+
+// DeleteWhere removes every row of a table matching pred.
+func DeleteWhere(ctx context.Context, db sq.BaseRunner, table string, pred interface{},
+	args ...interface{}) error {
+	_, err := sq.StatementBuilder.RunWith(db).
+		Delete(table).
+		Where(pred, args...).
+		ExecContext(ctx)
+	return Error(err)
+}
+
+// fieldValues dereferences a record's field references into the values to
+// store, in the same order as its columns.
+func fieldValues(rec st.Recorder, withKeys bool) []interface{} {
+	refs := rec.FieldReferences(withKeys)
+	values := make([]interface{}, len(refs))
+	for i, ref := range refs {
+		values[i] = reflect.ValueOf(ref).Elem().Interface()
+	}
+	return values
+}
+
+// likeEscape is the escape character used by Like, chosen because it needs no
+// quoting in either MySQL or SQLite string literals.
+const likeEscape = "!"
+
+// EscapeLike escapes the LIKE wildcards in s so that it matches literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape,
+		"%", likeEscape+"%", "_", likeEscape+"_").Replace(s)
+}
+
+// Like matches column against a LIKE pattern escaped with EscapeLike.
+func Like(column, pattern string) sq.Sqlizer {
+	return sq.Expr(column+" LIKE ? ESCAPE '"+likeEscape+"'", pattern)
+}
@@ -0,0 +1,149 @@
+// Package storage opens the SQL databases backing the repositories and hides
+// the differences between the supported drivers.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/angelcaban/mud/apperr"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Supported values for the database driver setting
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite3"
+	DriverMemory = "memory"
+)
+
+const (
+	mysqlErrDuplicateEntry = 1062
+	mysqlErrNoSuchTable    = 1146
+)
+
+var ErrUnknownDriver = errors.New("Unknown Database Driver")
+
+// Pool limits the connections kept to a database. Zero values leave the
+// database/sql defaults in place.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Open connects to the SQL database described by driver and dsn, and checks
+// that it is reachable.
+func Open(driver, dsn string, pool Pool) (*sql.DB, error) {
+	switch driver {
+	case DriverMySQL, DriverSQLite:
+	default:
+		return nil, ErrUnknownDriver
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+
+	// SQLite allows a single writer at a time; sharing one connection avoids
+	// "database is locked" errors under concurrent requests.
+	if driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Error maps a failure talking to the database onto a domain error, keeping
+// it as the cause. Timeouts, lost connections and locked databases are worth
+// retrying, anything else is internal.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var sqliteErr sqlite3.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone), errors.Is(err, mysql.ErrInvalidConn):
+		return apperr.ErrUnavailable.Wrap(err)
+	case errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked):
+		return apperr.ErrUnavailable.Wrap(err)
+	}
+	return apperr.ErrInternal.Wrap(err)
+}
+
+// IsDuplicateKey reports whether err was caused by violating a primary key or
+// unique constraint.
+func IsDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicateEntry
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
+
+// IsMissingTable reports whether err was caused by querying a table that does
+// not exist.
+func IsMissingTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrNoSuchTable
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrError &&
+			strings.HasPrefix(sqliteErr.Error(), "no such table")
+	}
+
+	return false
+}
+
+type stmtCacheProxy struct {
+	*sq.StmtCache
+	db *sql.DB
+}
+
+// NewStmtCacheProxy caches prepared statements for db like squirrel's own
+// proxy does, but keeps the context aware methods of the cache reachable.
+func NewStmtCacheProxy(db *sql.DB) sq.DBProxyBeginner {
+	return &stmtCacheProxy{
+		StmtCache: sq.NewStmtCache(db),
+		db:        db,
+	}
+}
+
+func (p *stmtCacheProxy) Begin() (*sql.Tx, error) {
+	return p.db.Begin()
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+var ErrNestedTransaction = errors.New("Transaction Already In Progress")
+
+// Transactor runs units of work: functions whose repository calls all share
+// one database transaction.
+type Transactor interface {
+	// Within runs fn in a transaction, committing it if fn returns nil and
+	// rolling it back if fn fails or panics. Units of work started inside fn
+	// join the transaction already in progress.
+	Within(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+type txState struct {
+	db    *sql.DB
+	proxy sq.DBProxyBeginner
+}
+
+type transactor struct {
+	db *sql.DB
+}
+
+// NewTransactor returns a Transactor for the repositories opened on db.
+func NewTransactor(db *sql.DB) Transactor {
+	return &transactor{db: db}
+}
+
+func (t *transactor) Within(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok && state.db == t.db {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Error(err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	ctx = context.WithValue(ctx, txKey{}, &txState{
+		db:    t.db,
+		proxy: &txProxy{sq.NewStmtCache(tx)},
+	})
+	if err := fn(ctx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return Error(tx.Commit())
+}
+
+// Runner returns what a repository opened with NewStmtCacheProxy should run
+// its statements on: the transaction of the unit of work ctx belongs to, if
+// there is one on the same database, or the repository's own proxy.
+func Runner(ctx context.Context, db sq.DBProxyBeginner) sq.DBProxyBeginner {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return db
+	}
+	if p, ok := db.(*stmtCacheProxy); ok && p.db == state.db {
+		return state.proxy
+	}
+	return db
+}
+
+// txProxy caches the statements prepared within a single transaction.
+type txProxy struct {
+	*sq.StmtCache
+}
+
+func (p *txProxy) Begin() (*sql.Tx, error) {
+	return nil, ErrNestedTransaction
+}